@@ -0,0 +1,198 @@
+package alerts
+
+import (
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/mcuadros/go-defaults"
+	"log"
+	"modbus2prometheus/controller"
+	"sync"
+	"time"
+)
+
+// Размер очереди событий одного подписчика
+const eventQueueSize = 64
+
+type EventKind uint8
+
+const (
+	EVENT_FIRING   EventKind = iota // Тревога сработала
+	EVENT_REPEAT                    // Повторное уведомление об активной тревоге
+	EVENT_RESOLVED                  // Тревога снята
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EVENT_REPEAT:
+		return "repeat"
+	case EVENT_RESOLVED:
+		return "resolved"
+	}
+	return "firing"
+}
+
+// Event Уведомление о смене состояния тревоги
+type Event struct {
+	Kind     EventKind
+	Rule     string
+	Severity string
+	Time     time.Time
+	Duration time.Duration // Сколько тревога активна
+	Text     string        // Готовый текст уведомления
+}
+
+// Config Настройки проверки тревог
+type Config struct {
+	Rules       []RuleConfig
+	Interval    time.Duration `default:"10s"` // Период проверки правил
+	SilenceFile string        // Файл заглушек, чтобы они переживали перезапуск
+	Ctrl        *controller.Controller
+}
+
+// Manager Периодически проверяет правила по значениям тегов и рассылает уведомления подписчикам
+type Manager struct {
+	conf     Config
+	mu       sync.Mutex // Защищает состояние правил и заглушки
+	rules    []*Rule
+	silences map[string]*Silence
+
+	subscribers []chan Event
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New Разбирает правила, проверка начнется после вызова Start
+func New(conf Config) (*Manager, error) {
+	defaults.SetDefaults(&conf)
+
+	m := &Manager{
+		conf:     conf,
+		silences: make(map[string]*Silence),
+		done:     make(chan struct{}),
+	}
+	names := make(map[string]bool)
+	for _, rc := range conf.Rules {
+		if names[rc.Name] {
+			return nil, fmt.Errorf("alert %s: duplicate name", rc.Name)
+		}
+		names[rc.Name] = true
+
+		rule, err := newRule(rc, conf.Ctrl)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, rule)
+
+		metrics.NewGauge(fmt.Sprintf(`modbus_alert_firing{alert=%q}`, rule.Name), func() float64 {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if rule.State == STATE_FIRING {
+				return 1
+			}
+			return 0
+		})
+	}
+
+	if err := m.loadSilences(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Subscribe Подписка на уведомления, вызывается до Start. Канал закрывается после Stop.
+func (m *Manager) Subscribe() <-chan Event {
+	ch := make(chan Event, eventQueueSize)
+
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, ch)
+	m.mu.Unlock()
+
+	return ch
+}
+
+// Start Запускает проверку правил
+func (m *Manager) Start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Stop Останавливает проверку и закрывает каналы подписчиков
+func (m *Manager) Stop() {
+	close(m.done)
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subscribers {
+		close(ch)
+	}
+	m.subscribers = nil
+}
+
+func (m *Manager) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.eval(time.Now())
+		}
+	}
+}
+
+// eval Проверяет все правила. Если значений тегов нет, состояние правила не меняется.
+func (m *Manager) eval(now time.Time) {
+	type result struct {
+		active, clear bool
+		err           error
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]result, len(m.rules))
+	m.conf.Ctrl.RLock()
+	for i, rule := range m.rules {
+		results[i].active, results[i].clear, results[i].err = rule.check(now)
+	}
+	m.conf.Ctrl.RUnlock()
+
+	for i, rule := range m.rules {
+		if err := results[i].err; err != nil {
+			// Ошибку пишем в лог только при её появлении
+			if rule.Error != err.Error() {
+				log.Printf("Alert %s error: %s", rule.Name, err.Error())
+			}
+			rule.Error = err.Error()
+			continue
+		}
+		rule.Error = ""
+
+		e := rule.step(results[i].active, results[i].clear, now)
+		if e == nil {
+			continue
+		}
+		// Заглушенные и подтвержденные тревоги меняют состояние, но не рассылаются
+		if m.silenced(rule.Name, now) {
+			log.Printf("Alert %s %s, silenced", rule.Name, e.Kind)
+			continue
+		}
+		if e.Kind == EVENT_REPEAT && !rule.AckedAt.IsZero() {
+			continue
+		}
+		log.Printf("Alert %s %s", rule.Name, e.Kind)
+		for _, ch := range m.subscribers {
+			select {
+			case ch <- *e:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package alerts
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// JsonAlert Состояние правила в /api/v1/alerts
+type JsonAlert struct {
+	Name       string            `json:"name"`
+	Expr       string            `json:"expr"`
+	Clear      string            `json:"clear,omitempty"`
+	For        string            `json:"for,omitempty"`
+	Severity   string            `json:"severity,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	State      string            `json:"state"`
+	ActiveAt   *time.Time        `json:"active_at,omitempty"`
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`
+	NotifiedAt *time.Time        `json:"notified_at,omitempty"`
+	AckedAt    *time.Time        `json:"acked_at,omitempty"`
+	AckedBy    string            `json:"acked_by,omitempty"`
+	Silenced   *time.Time        `json:"silenced_until,omitempty"`
+	Values     map[string]string `json:"values,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func jsonTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Alerts Текущее состояние всех правил
+func (m *Manager) Alerts() []JsonAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	res := make([]JsonAlert, 0, len(m.rules))
+	for _, r := range m.rules {
+		a := JsonAlert{
+			Name:       r.Name,
+			Expr:       r.Expr,
+			Clear:      r.Clear,
+			Severity:   r.Severity,
+			Message:    r.Message,
+			State:      r.State.String(),
+			ActiveAt:   jsonTime(r.ActiveAt),
+			FiredAt:    jsonTime(r.FiredAt),
+			NotifiedAt: jsonTime(r.NotifiedAt),
+			AckedAt:    jsonTime(r.AckedAt),
+			AckedBy:    r.AckedBy,
+			Values:     r.Values,
+			Error:      r.Error,
+		}
+		if m.silenced(r.Name, now) {
+			a.Silenced = jsonTime(m.silences[r.Name].Until)
+		}
+		if r.For > 0 {
+			a.For = r.For.String()
+		}
+		res = append(res, a)
+	}
+
+	return res
+}
+
+// Handler GET /api/v1/alerts
+func (m *Manager) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.Alerts()); err != nil {
+			log.Println("Cannot send response")
+		}
+	}
+}
+
+// SilencesHandler GET /api/v1/silences
+func (m *Manager) SilencesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.Silences()); err != nil {
+			log.Println("Cannot send response")
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package alerts
+
+import (
+	"fmt"
+	"modbus2prometheus/controller"
+	"sort"
+	"strings"
+	"time"
+)
+
+type State uint8
+
+const (
+	STATE_INACTIVE State = iota // Условие не выполняется
+	STATE_PENDING               // Условие выполняется меньше For
+	STATE_FIRING                // Тревога
+)
+
+func (s State) String() string {
+	switch s {
+	case STATE_PENDING:
+		return "pending"
+	case STATE_FIRING:
+		return "firing"
+	}
+	return "inactive"
+}
+
+// RuleConfig Описание правила тревоги
+type RuleConfig struct {
+	Name     string
+	Expr     string        // Условие тревоги, например "temp_boiler > 85" или "bit(status, 5)"
+	Clear    string        // Условие снятия тревоги для гистерезиса, по умолчанию невыполнение Expr
+	For      time.Duration // Сколько условие должно выполняться до срабатывания
+	Repeat   time.Duration // Период повторного уведомления, пока тревога активна
+	Severity string
+	Message  string // Текст уведомления, по умолчанию имя правила
+}
+
+// Rule Правило тревоги с текущим состоянием
+type Rule struct {
+	RuleConfig
+	expr  *controller.Expr
+	clear *controller.Expr
+	deps  map[string]*controller.Tag
+
+	State      State
+	ActiveAt   time.Time // Когда условие начало выполняться
+	FiredAt    time.Time // Когда сработала тревога
+	NotifiedAt time.Time // Последнее уведомление
+	AckedAt    time.Time // Когда тревогу подтвердили, сбрасывается при снятии
+	AckedBy    string
+	Values     map[string]string // Значения тегов правила при последней проверке
+	Error      string            // Ошибка последней проверки, состояние при ней не меняется
+}
+
+// newRule Разбирает выражения правила и находит теги, от которых оно зависит
+func newRule(conf RuleConfig, ctrl *controller.Controller) (*Rule, error) {
+	if conf.Name == "" {
+		return nil, fmt.Errorf("alert rule %q: name is empty", conf.Expr)
+	}
+
+	r := &Rule{RuleConfig: conf, deps: make(map[string]*controller.Tag)}
+	var err error
+	if r.expr, err = controller.ParseExpr(conf.Expr); err != nil {
+		return nil, fmt.Errorf("alert %s: %w", conf.Name, err)
+	}
+	vars := r.expr.Vars()
+	if conf.Clear != "" {
+		if r.clear, err = controller.ParseExpr(conf.Clear); err != nil {
+			return nil, fmt.Errorf("alert %s: %w", conf.Name, err)
+		}
+		vars = append(vars, r.clear.Vars()...)
+	}
+
+	for _, name := range vars {
+		tag := ctrl.FindTag(name)
+		if tag == nil {
+			return nil, fmt.Errorf("alert %s: unknown tag %s", conf.Name, name)
+		}
+		r.deps[name] = tag
+	}
+
+	return r, nil
+}
+
+// lookup Значение тега правила, устаревшие значения не используются. Вызывается под
+// блокировкой контроллера.
+func (r *Rule) lookup(now time.Time) func(string) (float64, bool) {
+	return func(name string) (float64, bool) {
+		tag := r.deps[name]
+		if tag == nil || tag.Quality(now) != controller.QUALITY_GOOD {
+			return 0, false
+		}
+		return controller.ValToFloat(tag.LastValue)
+	}
+}
+
+// check Вычисляет условия правила, вызывается под блокировкой контроллера
+func (r *Rule) check(now time.Time) (active, clear bool, err error) {
+	values := make(map[string]string, len(r.deps))
+	for name, tag := range r.deps {
+		values[name] = controller.ValToStrWithUnit(tag)
+	}
+	r.Values = values
+
+	val, err := r.expr.Eval(r.lookup(now))
+	if err != nil {
+		return false, false, err
+	}
+	active = val != 0
+	clear = !active
+	if r.clear != nil {
+		val, err = r.clear.Eval(r.lookup(now))
+		if err != nil {
+			return false, false, err
+		}
+		clear = val != 0
+	}
+
+	return active, clear, nil
+}
+
+// step Переводит правило в следующее состояние, возвращает событие для уведомления
+func (r *Rule) step(active, clear bool, now time.Time) *Event {
+	switch r.State {
+	case STATE_INACTIVE, STATE_PENDING:
+		if !active {
+			r.State = STATE_INACTIVE
+			r.ActiveAt = time.Time{}
+			return nil
+		}
+		if r.State == STATE_INACTIVE {
+			r.State = STATE_PENDING
+			r.ActiveAt = now
+		}
+		if now.Sub(r.ActiveAt) < r.For {
+			return nil
+		}
+		r.State = STATE_FIRING
+		r.FiredAt = now
+		r.NotifiedAt = now
+		return r.event(EVENT_FIRING, now)
+	case STATE_FIRING:
+		if clear {
+			e := r.event(EVENT_RESOLVED, now)
+			r.State = STATE_INACTIVE
+			r.ActiveAt = time.Time{}
+			r.FiredAt = time.Time{}
+			r.AckedAt = time.Time{}
+			r.AckedBy = ""
+			r.NotifiedAt = now
+			return e
+		}
+		if r.Repeat > 0 && now.Sub(r.NotifiedAt) >= r.Repeat {
+			r.NotifiedAt = now
+			return r.event(EVENT_REPEAT, now)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) event(kind EventKind, now time.Time) *Event {
+	e := &Event{
+		Kind:     kind,
+		Rule:     r.Name,
+		Severity: r.Severity,
+		Time:     now,
+	}
+	if kind != EVENT_FIRING {
+		e.Duration = now.Sub(r.FiredAt)
+	}
+	e.Text = r.text(e)
+
+	return e
+}
+
+// text Текст уведомления: заголовок, условие и значения тегов
+func (r *Rule) text(e *Event) string {
+	title := r.Message
+	if title == "" {
+		title = r.Name
+	}
+	if r.Severity != "" {
+		title = "[" + r.Severity + "] " + title
+	}
+
+	var sb strings.Builder
+	switch e.Kind {
+	case EVENT_FIRING:
+		sb.WriteString("Тревога: " + title + "\n")
+	case EVENT_REPEAT:
+		sb.WriteString("Тревога продолжается " + e.Duration.Round(time.Second).String() + ": " + title + "\n")
+	case EVENT_RESOLVED:
+		sb.WriteString("Тревога снята через " + e.Duration.Round(time.Second).String() + ": " + title + "\n")
+	}
+	sb.WriteString(r.Expr + "\n")
+
+	names := make([]string, 0, len(r.Values))
+	for name := range r.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sb.WriteString(name + ": " + r.Values[name] + "\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,153 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+)
+
+// Без события
+const noEvent EventKind = 255
+
+func TestRuleStep(t *testing.T) {
+	type step struct {
+		at     time.Duration // От начала теста
+		active bool
+		clear  bool
+		ack    bool // Подтвердить тревогу перед шагом
+		state  State
+		event  EventKind
+	}
+
+	tests := []struct {
+		name  string
+		conf  RuleConfig
+		steps []step
+	}{
+		{
+			name: "fires at once without for",
+			conf: RuleConfig{Name: "a"},
+			steps: []step{
+				{at: 0, active: false, clear: true, state: STATE_INACTIVE, event: noEvent},
+				{at: time.Second, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+				{at: 2 * time.Second, active: true, state: STATE_FIRING, event: noEvent},
+				{at: 3 * time.Second, active: false, clear: true, state: STATE_INACTIVE, event: EVENT_RESOLVED},
+			},
+		},
+		{
+			name: "for delay",
+			conf: RuleConfig{Name: "a", For: time.Minute},
+			steps: []step{
+				{at: 0, active: true, state: STATE_PENDING, event: noEvent},
+				{at: 59 * time.Second, active: true, state: STATE_PENDING, event: noEvent},
+				{at: time.Minute, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+			},
+		},
+		{
+			name: "pending resets when condition drops",
+			conf: RuleConfig{Name: "a", For: time.Minute},
+			steps: []step{
+				{at: 0, active: true, state: STATE_PENDING, event: noEvent},
+				{at: 50 * time.Second, active: false, clear: true, state: STATE_INACTIVE, event: noEvent},
+				{at: 60 * time.Second, active: true, state: STATE_PENDING, event: noEvent},
+				{at: 110 * time.Second, active: true, state: STATE_PENDING, event: noEvent},
+				{at: 120 * time.Second, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+			},
+		},
+		{
+			name: "clear hysteresis",
+			conf: RuleConfig{Name: "a", Clear: "temp < 80"},
+			steps: []step{
+				{at: 0, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+				// Условие тревоги уже не выполняется, но условие снятия еще нет
+				{at: time.Second, active: false, clear: false, state: STATE_FIRING, event: noEvent},
+				{at: 2 * time.Second, active: false, clear: true, state: STATE_INACTIVE, event: EVENT_RESOLVED},
+			},
+		},
+		{
+			name: "repeat",
+			conf: RuleConfig{Name: "a", Repeat: 10 * time.Minute},
+			steps: []step{
+				{at: 0, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+				{at: 9 * time.Minute, active: true, state: STATE_FIRING, event: noEvent},
+				{at: 10 * time.Minute, active: true, state: STATE_FIRING, event: EVENT_REPEAT},
+				{at: 15 * time.Minute, active: true, state: STATE_FIRING, event: noEvent},
+				{at: 20 * time.Minute, active: true, state: STATE_FIRING, event: EVENT_REPEAT},
+			},
+		},
+		{
+			name: "ack is reset on resolve",
+			conf: RuleConfig{Name: "a", Repeat: time.Minute},
+			steps: []step{
+				{at: 0, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+				// Подтверждение не меняет состояние, повторы отсекает Manager
+				{at: time.Minute, active: true, ack: true, state: STATE_FIRING, event: EVENT_REPEAT},
+				{at: 2 * time.Minute, clear: true, state: STATE_INACTIVE, event: EVENT_RESOLVED},
+				{at: 3 * time.Minute, active: true, state: STATE_FIRING, event: EVENT_FIRING},
+			},
+		},
+	}
+
+	start := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Rule{RuleConfig: tt.conf}
+			var firedAt time.Time
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				if s.ack {
+					r.AckedAt, r.AckedBy = now, "owner"
+				}
+
+				e := r.step(s.active, s.clear, now)
+				if r.State != s.state {
+					t.Errorf("step %d: state %s, want %s", i, r.State, s.state)
+				}
+				switch {
+				case s.event == noEvent && e != nil:
+					t.Errorf("step %d: event %s, want none", i, e.Kind)
+				case s.event != noEvent && e == nil:
+					t.Errorf("step %d: no event, want %s", i, s.event)
+				case e != nil && e.Kind != s.event:
+					t.Errorf("step %d: event %s, want %s", i, e.Kind, s.event)
+				}
+				if e == nil {
+					continue
+				}
+
+				if e.Kind == EVENT_FIRING {
+					firedAt = now
+				}
+				if e.Kind != EVENT_FIRING && e.Duration != now.Sub(firedAt) {
+					t.Errorf("step %d: duration %s, want %s", i, e.Duration, now.Sub(firedAt))
+				}
+				if e.Kind == EVENT_RESOLVED && (!r.AckedAt.IsZero() || r.AckedBy != "" || !r.FiredAt.IsZero()) {
+					t.Errorf("step %d: ack %s by %q and fired at %s kept after resolve", i, r.AckedAt, r.AckedBy, r.FiredAt)
+				}
+			}
+		})
+	}
+}
+
+func TestRuleText(t *testing.T) {
+	r := &Rule{
+		RuleConfig: RuleConfig{Name: "boiler_overheat", Expr: "temp_boiler > 85", Severity: "critical", Message: "Перегрев котла"},
+		Values:     map[string]string{"temp_boiler": "87.5 °C", "pump": "on"},
+	}
+
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{Event{Kind: EVENT_FIRING}, "Тревога: [critical] Перегрев котла\ntemp_boiler > 85\npump: on\ntemp_boiler: 87.5 °C\n"},
+		{Event{Kind: EVENT_REPEAT, Duration: 30 * time.Minute},
+			"Тревога продолжается 30m0s: [critical] Перегрев котла\ntemp_boiler > 85\npump: on\ntemp_boiler: 87.5 °C\n"},
+		{Event{Kind: EVENT_RESOLVED, Duration: 95 * time.Second},
+			"Тревога снята через 1m35s: [critical] Перегрев котла\ntemp_boiler > 85\npump: on\ntemp_boiler: 87.5 °C\n"},
+	}
+
+	for _, tt := range tests {
+		if got := r.text(&tt.e); got != tt.want {
+			t.Errorf("text(%s) =\n%s\nwant\n%s", tt.e.Kind, got, tt.want)
+		}
+	}
+}
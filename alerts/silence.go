@@ -0,0 +1,172 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Silence Заглушенная тревога, уведомления по правилу не отправляются до Until
+type Silence struct {
+	Alert   string    `json:"alert"`
+	Until   time.Time `json:"until"`
+	By      string    `json:"by,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// findRule Ищет правило по имени, вызывается под блокировкой
+func (m *Manager) findRule(name string) *Rule {
+	for _, r := range m.rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// silenced Заглушено ли правило, истекшие заглушки удаляются. Вызывается под блокировкой.
+func (m *Manager) silenced(name string, now time.Time) bool {
+	s := m.silences[name]
+	if s == nil {
+		return false
+	}
+	if now.Before(s.Until) {
+		return true
+	}
+
+	log.Printf("Alert %s silence expired", name)
+	delete(m.silences, name)
+	m.saveSilences()
+	return false
+}
+
+// Ack Подтверждает активную тревогу, повторные уведомления по ней не отправляются до снятия
+func (m *Manager) Ack(name, by string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.findRule(name)
+	if r == nil {
+		return fmt.Errorf("alert %s not found", name)
+	}
+	if r.State != STATE_FIRING {
+		return fmt.Errorf("alert %s is not firing", name)
+	}
+	r.AckedBy = by
+	r.AckedAt = time.Now()
+	log.Printf("Alert %s acknowledged by %s", name, by)
+
+	return nil
+}
+
+// Silence Заглушает уведомления по правилу на d
+func (m *Manager) Silence(name string, d time.Duration, by string) (Silence, error) {
+	if d <= 0 {
+		return Silence{}, fmt.Errorf("bad silence duration %s", d)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findRule(name) == nil {
+		return Silence{}, fmt.Errorf("alert %s not found", name)
+	}
+	now := time.Now()
+	s := &Silence{Alert: name, Until: now.Add(d), By: by, Created: now}
+	m.silences[name] = s
+	m.saveSilences()
+	log.Printf("Alert %s silenced for %s by %s", name, d, by)
+
+	return *s, nil
+}
+
+// Unsilence Снимает заглушку с правила
+func (m *Manager) Unsilence(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.silences[name] == nil {
+		return fmt.Errorf("alert %s is not silenced", name)
+	}
+	delete(m.silences, name)
+	m.saveSilences()
+	log.Printf("Alert %s unsilenced", name)
+
+	return nil
+}
+
+// Silences Действующие заглушки, отсортированные по имени правила
+func (m *Manager) Silences() []Silence {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	res := make([]Silence, 0, len(m.silences))
+	for name, s := range m.silences {
+		if m.silenced(name, now) {
+			res = append(res, *s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Alert < res[j].Alert })
+
+	return res
+}
+
+// loadSilences Читает заглушки из файла, истекшие и заглушки удаленных правил пропускаются
+func (m *Manager) loadSilences() error {
+	if m.conf.SilenceFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.conf.SilenceFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var silences []Silence
+	if err := json.Unmarshal(data, &silences); err != nil {
+		return fmt.Errorf("%s: %w", m.conf.SilenceFile, err)
+	}
+
+	now := time.Now()
+	for i, s := range silences {
+		if m.findRule(s.Alert) == nil || !now.Before(s.Until) {
+			continue
+		}
+		m.silences[s.Alert] = &silences[i]
+	}
+
+	return nil
+}
+
+// saveSilences Записывает заглушки в файл через временный файл, вызывается под блокировкой
+func (m *Manager) saveSilences() {
+	if m.conf.SilenceFile == "" {
+		return
+	}
+
+	silences := make([]Silence, 0, len(m.silences))
+	for _, s := range m.silences {
+		silences = append(silences, *s)
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].Alert < silences[j].Alert })
+
+	data, err := json.MarshalIndent(silences, "", "  ")
+	if err == nil {
+		tmp := m.conf.SilenceFile + ".tmp"
+		err = os.WriteFile(tmp, data, 0644)
+		if err == nil {
+			err = os.Rename(tmp, m.conf.SilenceFile)
+		}
+	}
+	if err != nil {
+		log.Printf("Alert silences save error: %s", err.Error())
+	}
+}
@@ -0,0 +1,147 @@
+package alerts
+
+import (
+	"encoding/json"
+	"modbus2prometheus/controller"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testManager(t *testing.T, file string, names ...string) *Manager {
+	ctrl, err := controller.New(&controller.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []RuleConfig
+	for _, name := range names {
+		rules = append(rules, RuleConfig{Name: name, Expr: "1"})
+	}
+
+	m, err := New(Config{Rules: rules, SilenceFile: file, Ctrl: ctrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// restart Менеджер с теми же правилами после перезапуска. Метрики правил уже
+// зарегистрированы, поэтому он собирается без New.
+func restart(m *Manager) *Manager {
+	return &Manager{conf: m.conf, rules: m.rules, silences: make(map[string]*Silence), done: make(chan struct{})}
+}
+
+func reload(t *testing.T, m *Manager) *Manager {
+	res := restart(m)
+	if err := res.loadSilences(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func silenceNames(silences []Silence) (names []string) {
+	for _, s := range silences {
+		names = append(names, s.Alert)
+	}
+	return
+}
+
+func TestSilencePersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "silences.json")
+	m := testManager(t, file, "t25_short", "t25_long", "t25_other")
+
+	short, err := m.Silence("t25_short", time.Hour, "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Silence("t25_long", 24*time.Hour, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Silence("t25_unknown", time.Hour, "owner"); err == nil {
+		t.Error("Silence() of unknown rule, want error")
+	}
+	if _, err := m.Silence("t25_other", 0, "owner"); err == nil {
+		t.Error("Silence() for 0, want error")
+	}
+
+	// Заглушки переживают перезапуск
+	m2 := reload(t, m)
+	got := m2.Silences()
+	if names := silenceNames(got); len(names) != 2 || names[0] != "t25_long" || names[1] != "t25_short" {
+		t.Fatalf("Silences() after reload = %v, want [t25_long t25_short]", names)
+	}
+	if s := got[1]; !s.Until.Equal(short.Until) || s.By != "owner" || !s.Created.Equal(short.Created) {
+		t.Errorf("reloaded silence %+v, want %+v", s, short)
+	}
+
+	// Истекшая заглушка удаляется при проверке и больше не загружается
+	later := time.Now().Add(2 * time.Hour)
+	if m2.silenced("t25_short", later) {
+		t.Error("t25_short silenced after expiry")
+	}
+	if !m2.silenced("t25_long", later) {
+		t.Error("t25_long not silenced before expiry")
+	}
+	if names := silenceNames(reload(t, m2).Silences()); len(names) != 1 || names[0] != "t25_long" {
+		t.Errorf("Silences() after expiry and reload = %v, want [t25_long]", names)
+	}
+
+	if err := m2.Unsilence("t25_long"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Unsilence("t25_long"); err == nil {
+		t.Error("second Unsilence(), want error")
+	}
+	if names := silenceNames(reload(t, m2).Silences()); len(names) != 0 {
+		t.Errorf("Silences() after unsilence and reload = %v, want none", names)
+	}
+}
+
+func TestLoadSilencesSkipsStale(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "silences.json")
+	now := time.Now()
+	data, err := json.Marshal([]Silence{
+		{Alert: "t25_kept", Until: now.Add(time.Hour), Created: now},
+		{Alert: "t25_expired", Until: now.Add(-time.Minute), Created: now.Add(-time.Hour)},
+		{Alert: "t25_removed", Until: now.Add(time.Hour), Created: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testManager(t, file, "t25_kept", "t25_expired")
+	if names := silenceNames(m.Silences()); len(names) != 1 || names[0] != "t25_kept" {
+		t.Errorf("Silences() = %v, want [t25_kept]", names)
+	}
+
+	if err := os.WriteFile(file, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restart(m).loadSilences(); err == nil {
+		t.Error("loadSilences() of broken file, want error")
+	}
+}
+
+// TestSilencedEval Заглушенное правило меняет состояние, но уведомления не рассылаются
+func TestSilencedEval(t *testing.T) {
+	m := testManager(t, "", "t25_eval")
+	events := m.Subscribe()
+
+	if _, err := m.Silence("t25_eval", time.Hour, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	m.eval(now)
+	if r := m.findRule("t25_eval"); r.State != STATE_FIRING {
+		t.Errorf("silenced rule state %s, want firing", r.State)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("silenced rule sent %s event", e.Kind)
+	default:
+	}
+}
@@ -3,16 +3,75 @@ package main
 import (
 	"fmt"
 	"gopkg.in/yaml.v2"
+	"modbus2prometheus/controller"
 	"os"
 	"time"
 )
 
 type TagConfig struct {
-	Name      string `yaml:"name"`
-	Desc      string `yaml:"desc"`
-	Address   uint16 `yaml:"address"`
-	Operation string `yaml:"operation"`
-	Group     string `yaml:"group"`
+	Name         string            `yaml:"name"`
+	Desc         string            `yaml:"desc"`
+	Address      uint16            `yaml:"address"`
+	Operation    string            `yaml:"operation"`
+	RegisterType string            `yaml:"register-type"`
+	Type         string            `yaml:"type"`
+	Length       uint16            `yaml:"length"`
+	ByteOrder    string            `yaml:"byte-order"`
+	WordOrder    string            `yaml:"word-order"`
+	Scale        float64           `yaml:"scale"`
+	Offset       float64           `yaml:"offset"`
+	Unit         string            `yaml:"unit"`
+	Expr         string            `yaml:"expr"`
+	Group        string            `yaml:"group"`
+	Interval     time.Duration     `yaml:"interval"`
+	Bits         []BitConfig       `yaml:"bits"`
+	Values       map[int64]string  `yaml:"values"`
+	Min          *float64          `yaml:"min"`
+	Max          *float64          `yaml:"max"`
+	Step         float64           `yaml:"step"`
+	Verify       bool              `yaml:"verify"`
+	StaleAfter   time.Duration     `yaml:"stale-after"`
+	Labels       map[string]string `yaml:"labels"`
+}
+
+// BitConfig Битовый тег, значение берется из бита родительского регистра
+type BitConfig struct {
+	Bit       uint8             `yaml:"bit"`
+	Name      string            `yaml:"name"`
+	Desc      string            `yaml:"desc"`
+	Group     string            `yaml:"group"`
+	Operation string            `yaml:"operation"`
+	Values    map[int64]string  `yaml:"values"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+// GroupConfig Общие настройки тегов группы
+type GroupConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DeviceConfig Устройство на шине со своим адресом и набором тегов
+type DeviceConfig struct {
+	Name      string      `yaml:"name"`
+	Id        uint8       `yaml:"id"`
+	ByteOrder string      `yaml:"byte-order"`
+	WordOrder string      `yaml:"word-order"`
+	Tags      []TagConfig `yaml:"tags"`
+}
+
+// ConnectionConfig Соединение с шиной (шлюз или порт), опрашивается независимо от остальных
+type ConnectionConfig struct {
+	Name         string         `yaml:"name"`
+	Url          string         `yaml:"url"`
+	Speed        uint           `yaml:"speed"`
+	Timeout      time.Duration  `yaml:"timeout"`
+	PollingTime  time.Duration  `yaml:"polling-time"`
+	ReadPeriod   time.Duration  `yaml:"read-period"`
+	MaxGap       uint16         `yaml:"max-gap"`
+	MaxBlock     uint16         `yaml:"max-block"`
+	WriteTimeout time.Duration  `yaml:"write-timeout"`
+	StaleAfter   time.Duration  `yaml:"stale-after"`
+	Devices      []DeviceConfig `yaml:"devices"`
 }
 
 type TelegramConfig struct {
@@ -21,15 +80,129 @@ type TelegramConfig struct {
 	NodeRedUrl string           `yaml:"nodeRedUrl"`
 }
 
+// MqttConfig Публикация значений в MQTT брокер
+type MqttConfig struct {
+	Url             string        `yaml:"url"`
+	ClientId        string        `yaml:"client-id"`
+	Username        string        `yaml:"username"`
+	Password        string        `yaml:"password"`
+	Topic           string        `yaml:"topic"`
+	Interval        time.Duration `yaml:"interval"`
+	Qos             byte          `yaml:"qos"`
+	Retain          bool          `yaml:"retain"`
+	Discovery       bool          `yaml:"discovery"`
+	DiscoveryPrefix string        `yaml:"discovery-prefix"`
+}
+
+// RemoteWriteConfig Отправка метрик по протоколу Prometheus remote_write
+type RemoteWriteConfig struct {
+	Url         string        `yaml:"url"`
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Username    string        `yaml:"username"`
+	Password    string        `yaml:"password"`
+	BearerToken string        `yaml:"bearer-token"`
+	BufferDir   string        `yaml:"buffer-dir"`
+	MaxBuffer   int64         `yaml:"max-buffer"`
+}
+
+// InfluxConfig Запись значений в InfluxDB
+type InfluxConfig struct {
+	Url           string        `yaml:"url"`
+	Org           string        `yaml:"org"`
+	Bucket        string        `yaml:"bucket"`
+	Token         string        `yaml:"token"`
+	Measurement   string        `yaml:"measurement"`
+	BatchSize     int           `yaml:"batch-size"`
+	FlushInterval time.Duration `yaml:"flush-interval"`
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxBuffer     int           `yaml:"max-buffer"`
+}
+
+// HistoryConfig Локальная история значений тегов
+type HistoryConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Retention    time.Duration `yaml:"retention"`
+	MaxPoints    int           `yaml:"max-points"`
+	File         string        `yaml:"file"`
+	SaveInterval time.Duration `yaml:"save-interval"`
+}
+
+// BackfillConfig Дозаливка сохраненных значений по remote_write
+type BackfillConfig struct {
+	Url         string        `yaml:"url"`
+	Username    string        `yaml:"username"`
+	Password    string        `yaml:"password"`
+	BearerToken string        `yaml:"bearer-token"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Interval    time.Duration `yaml:"interval"`
+}
+
+// TsdbConfig Встроенное хранилище значений на диске
+type TsdbConfig struct {
+	Dir            string         `yaml:"dir"`
+	Retention      time.Duration  `yaml:"retention"`
+	BlockDuration  time.Duration  `yaml:"block-duration"`
+	SyncInterval   time.Duration  `yaml:"sync-interval"`
+	MaxQueryPoints int            `yaml:"max-query-points"`
+	Backfill       BackfillConfig `yaml:"backfill"`
+}
+
+// AlertRuleConfig Правило тревоги
+type AlertRuleConfig struct {
+	Name     string        `yaml:"name"`
+	Expr     string        `yaml:"expr"`
+	Clear    string        `yaml:"clear"`
+	For      time.Duration `yaml:"for"`
+	Repeat   time.Duration `yaml:"repeat"`
+	Severity string        `yaml:"severity"`
+	Message  string        `yaml:"message"`
+}
+
+// AlertsConfig Тревоги по значениям тегов
+type AlertsConfig struct {
+	Interval    time.Duration     `yaml:"interval"`
+	SilenceFile string            `yaml:"silence-file"`
+	Rules       []AlertRuleConfig `yaml:"rules"`
+}
+
+// ConnectionAlertsConfig Уведомления о потере и восстановлении связи с устройствами
+type ConnectionAlertsConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Debounce   time.Duration `yaml:"debounce"`
+	Webhook    string        `yaml:"webhook"`
+	Timeout    time.Duration `yaml:"webhook-timeout"`
+	Retries    int           `yaml:"webhook-retries"`
+	RetryDelay time.Duration `yaml:"webhook-retry-delay"`
+}
+
 type Config struct {
-	DeviceUrl   string         `yaml:"device-url"`
-	DeviceId    uint8          `yaml:"device-id" default:"16"`
-	Speed       uint           `yaml:"speed" default:"19200"`
-	Timeout     time.Duration  `yaml:"timeout" default:"1s"`
-	PollingTime time.Duration  `yaml:"polling-time" default:"1s"`
-	ReadPeriod  time.Duration  `yaml:"read-period" default:"10ms"`
-	Tags        []TagConfig    `yaml:"tags"`
-	Telegram    TelegramConfig `yaml:"telegram"`
+	DeviceUrl    string                 `yaml:"device-url"`
+	DeviceId     uint8                  `yaml:"device-id" default:"16"`
+	Speed        uint                   `yaml:"speed" default:"19200"`
+	Timeout      time.Duration          `yaml:"timeout" default:"1s"`
+	PollingTime  time.Duration          `yaml:"polling-time" default:"1s"`
+	ReadPeriod   time.Duration          `yaml:"read-period" default:"10ms"`
+	ByteOrder    string                 `yaml:"byte-order"`
+	WordOrder    string                 `yaml:"word-order"`
+	MaxGap       uint16                 `yaml:"max-gap"`
+	MaxBlock     uint16                 `yaml:"max-block"`
+	WriteTimeout time.Duration          `yaml:"write-timeout"`
+	StaleAfter   time.Duration          `yaml:"stale-after"`
+	Tags         []TagConfig            `yaml:"tags"`
+	Devices      []DeviceConfig         `yaml:"devices"`
+	Connections  []ConnectionConfig     `yaml:"connections"`
+	Groups       map[string]GroupConfig `yaml:"groups"`
+	MetricPrefix string                 `yaml:"metric-prefix"`
+	MetricFamily string                 `yaml:"metric-family"`
+	Telegram     TelegramConfig         `yaml:"telegram"`
+	Mqtt         MqttConfig             `yaml:"mqtt"`
+	RemoteWrite  RemoteWriteConfig      `yaml:"remote-write"`
+	Influx       InfluxConfig           `yaml:"influx"`
+	History      HistoryConfig          `yaml:"history"`
+	Tsdb         TsdbConfig             `yaml:"tsdb"`
+	Alerts       AlertsConfig           `yaml:"alerts"`
+	ConnAlerts   ConnectionAlertsConfig `yaml:"connection-alerts"`
 }
 
 func NewConfig(configPath string) (config *Config, err error) {
@@ -53,6 +226,63 @@ func NewConfig(configPath string) (config *Config, err error) {
 	return config, nil
 }
 
+// AllDevices Возвращает список устройств, теги из корня конфига относятся к устройству device-id
+func (c *Config) AllDevices() []DeviceConfig {
+	devices := c.Devices
+	if len(c.Tags) > 0 {
+		devices = append([]DeviceConfig{{
+			Id:        c.DeviceId,
+			ByteOrder: c.ByteOrder,
+			WordOrder: c.WordOrder,
+			Tags:      c.Tags,
+		}}, devices...)
+	}
+
+	return devices
+}
+
+// TagInterval Период опроса тега: собственный, либо период группы
+func (c *Config) TagInterval(tag TagConfig) time.Duration {
+	if tag.Interval > 0 {
+		return tag.Interval
+	}
+
+	return c.Groups[tag.Group].Interval
+}
+
+// ParseEncoding Разбор порядка байт и регистров
+func ParseEncoding(byteOrder string, wordOrder string) (enc controller.Encoding, err error) {
+	enc.ByteOrder, err = controller.ParseByteOrder(byteOrder)
+	if err != nil {
+		return
+	}
+
+	enc.WordOrder, err = controller.ParseWordOrder(wordOrder)
+
+	return
+}
+
+// AllConnections Возвращает список соединений, устройства из корня конфига опрашиваются через device-url
+func (c *Config) AllConnections() []ConnectionConfig {
+	connections := c.Connections
+	if devices := c.AllDevices(); len(devices) > 0 {
+		connections = append([]ConnectionConfig{{
+			Url:          c.DeviceUrl,
+			Speed:        c.Speed,
+			Timeout:      c.Timeout,
+			PollingTime:  c.PollingTime,
+			ReadPeriod:   c.ReadPeriod,
+			MaxGap:       c.MaxGap,
+			MaxBlock:     c.MaxBlock,
+			WriteTimeout: c.WriteTimeout,
+			StaleAfter:   c.StaleAfter,
+			Devices:      devices,
+		}}, connections...)
+	}
+
+	return connections
+}
+
 // ValidateConfigPath just makes sure, that the path provided is a file,
 // that can be read
 func ValidateConfigPath(path string) error {
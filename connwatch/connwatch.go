@@ -0,0 +1,313 @@
+package connwatch
+
+import (
+	"fmt"
+	"github.com/mcuadros/go-defaults"
+	"log"
+	"modbus2prometheus/controller"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	checkInterval    = time.Second // Период проверки состояния соединений
+	eventQueueSize   = 16          // Размер очереди событий одного подписчика
+	webhookQueueSize = 64          // Сколько событий ждут отправки на webhook
+)
+
+type EventKind uint8
+
+const (
+	EVENT_DEGRADED  EventKind = iota // Ошибки связи
+	EVENT_DOWN                       // Связи нет
+	EVENT_RECOVERED                  // Связь восстановлена
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EVENT_DOWN:
+		return "down"
+	case EVENT_RECOVERED:
+		return "recovered"
+	}
+	return "degraded"
+}
+
+// Event Смена состояния соединения или устройства после подавления дребезга
+type Event struct {
+	Kind       EventKind
+	Connection string
+	Url        string
+	Device     string   // Устройство, пусто для событий самого соединения
+	Devices    []string // Устройства, связь с которыми нарушена или восстановлена
+	State      controller.ConnState
+	Prev       controller.ConnState
+	Error      string        // Последняя ошибка связи
+	Downtime   time.Duration // Сколько связь была нарушена
+	Time       time.Time
+	Text       string // Готовый текст уведомления
+}
+
+// Config Настройки уведомлений о состоянии связи
+type Config struct {
+	Debounce   time.Duration `default:"30s"` // Сколько новое состояние должно держаться до уведомления
+	Webhook    string        // Адрес, на который POST-ом отправляются события в JSON
+	Timeout    time.Duration `default:"10s"`
+	Retries    int           `default:"3"`  // Повторов отправки на webhook после ошибки
+	RetryDelay time.Duration `default:"5s"` // Пауза перед первым повтором, дальше удваивается
+	Ctrl       *controller.Controller
+}
+
+// watchedConn Состояние соединения или одного устройства на нем для подавления дребезга
+type watchedConn struct {
+	conn      *controller.Connection
+	dev       *controller.Device   // nil, если следим за самим соединением
+	reported  controller.ConnState // Последнее состояние, о котором сообщили
+	pending   controller.ConnState // Наблюдаемое состояние, ждет Debounce
+	pendingAt time.Time
+	failedAt  time.Time // Начало нарушения связи
+	lastErr   string
+}
+
+// Watcher Следит за состоянием соединений с шинами и устройств на них и рассылает уведомления
+// о переходах up -> degraded -> down -> recovered подписчикам и на webhook. Пока нет связи
+// с шиной, об устройствах отдельно не сообщается, они перечисляются в событии соединения.
+type Watcher struct {
+	conf   Config
+	client *http.Client
+	conns  []*watchedConn
+
+	mu          sync.Mutex
+	subscribers []chan Event
+
+	hooks chan *Event // События для webhook, отправляются в своей горутине
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New Создает наблюдатель, проверка начнется после вызова Start
+func New(conf Config) (*Watcher, error) {
+	defaults.SetDefaults(&conf)
+	if conf.Ctrl == nil {
+		return nil, fmt.Errorf("connwatch: controller is nil")
+	}
+
+	w := &Watcher{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		hooks:  make(chan *Event, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	now := time.Now()
+	for _, conn := range conf.Ctrl.Connections() {
+		w.conns = append(w.conns, &watchedConn{conn: conn, pendingAt: now})
+		for _, dev := range conn.Devices() {
+			w.conns = append(w.conns, &watchedConn{conn: conn, dev: dev, pendingAt: now})
+		}
+	}
+
+	return w, nil
+}
+
+// Subscribe Подписка на события, вызывается до Start. Канал закрывается после Stop.
+func (w *Watcher) Subscribe() <-chan Event {
+	ch := make(chan Event, eventQueueSize)
+
+	w.mu.Lock()
+	w.subscribers = append(w.subscribers, ch)
+	w.mu.Unlock()
+
+	return ch
+}
+
+// Start Запускает проверку состояния соединений
+func (w *Watcher) Start() {
+	w.wg.Add(1)
+	go w.run()
+	if w.conf.Webhook != "" {
+		w.wg.Add(1)
+		go w.runWebhook()
+	}
+}
+
+// Stop Останавливает проверку и закрывает каналы подписчиков
+func (w *Watcher) Stop() {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.subscribers {
+		close(ch)
+	}
+	w.subscribers = nil
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			for _, wc := range w.conns {
+				if e := w.check(wc, time.Now()); e != nil {
+					w.publish(e)
+				}
+			}
+		}
+	}
+}
+
+// state Текущее состояние соединения или устройства. Пока соединение не работает,
+// для устройства возвращается последнее отправленное состояние, т.е. изменений нет.
+func (wc *watchedConn) state() (controller.ConnState, error, time.Time) {
+	state, err := wc.conn.State()
+	since := wc.conn.StateSince()
+	if wc.dev == nil {
+		return state, err, since
+	}
+	if state != controller.CONN_UP && state != controller.CONN_DEGRADED {
+		return wc.reported, nil, since
+	}
+
+	state, err = wc.dev.State()
+	return state, err, wc.dev.StateSince()
+}
+
+// check Сравнивает состояние соединения или устройства с последним отправленным. Новое
+// состояние сообщается, только если продержалось Debounce.
+func (w *Watcher) check(wc *watchedConn, now time.Time) *Event {
+	state, err, since := wc.state()
+	if err != nil {
+		wc.lastErr = err.Error()
+	}
+	if state != controller.CONN_UP && wc.failedAt.IsZero() {
+		wc.failedAt = since
+	}
+
+	if state != wc.pending {
+		wc.pending = state
+		wc.pendingAt = now
+	}
+	if now.Sub(wc.pendingAt) < w.conf.Debounce {
+		return nil
+	}
+	// Связь держится Debounce, сбой закончился, даже если о нем не сообщали
+	if state == controller.CONN_UP {
+		defer func() {
+			wc.failedAt = time.Time{}
+			wc.lastErr = ""
+		}()
+	}
+	if wc.pending == wc.reported {
+		return nil
+	}
+
+	prev := wc.reported
+	wc.reported = wc.pending
+
+	var kind EventKind
+	switch state {
+	case controller.CONN_UP:
+		// О первом подключении не сообщаем
+		if prev == controller.CONN_CONNECTING {
+			return nil
+		}
+		kind = EVENT_RECOVERED
+	case controller.CONN_DEGRADED:
+		kind = EVENT_DEGRADED
+	case controller.CONN_DOWN:
+		kind = EVENT_DOWN
+	default:
+		return nil
+	}
+
+	e := &Event{
+		Kind:       kind,
+		Connection: wc.conn.Name(),
+		Url:        wc.conn.Url(),
+		State:      state,
+		Prev:       prev,
+		Error:      wc.lastErr,
+		Time:       now,
+	}
+	if wc.dev != nil {
+		e.Device = wc.dev.Name
+		e.Devices = []string{wc.dev.Name}
+	} else {
+		for _, dev := range wc.conn.Devices() {
+			e.Devices = append(e.Devices, dev.Name)
+		}
+	}
+	if !wc.failedAt.IsZero() {
+		e.Downtime = now.Sub(wc.failedAt)
+		if kind == EVENT_RECOVERED {
+			e.Downtime = since.Sub(wc.failedAt)
+		}
+	}
+	e.Text = text(e)
+
+	return e
+}
+
+// text Текст уведомления
+func text(e *Event) string {
+	downtime := e.Downtime.Round(time.Second).String()
+	target := e.Connection
+	if e.Device != "" {
+		target = "устройством " + e.Device + " (" + e.Connection + ")"
+	}
+
+	var res string
+	switch e.Kind {
+	case EVENT_DEGRADED:
+		res = "Связь с " + target + " нестабильна"
+	case EVENT_DOWN:
+		res = "Нет связи с " + target + " " + downtime
+	case EVENT_RECOVERED:
+		res = "Связь с " + target + " восстановлена, связи не было " + downtime
+	}
+	if e.Device == "" && len(e.Devices) > 0 {
+		res += "\nУстройства: " + strings.Join(e.Devices, ", ")
+	}
+	if e.Error != "" {
+		res += "\nПоследняя ошибка: " + e.Error
+	}
+
+	return res
+}
+
+// publish Рассылает событие подписчикам и на webhook
+func (w *Watcher) publish(e *Event) {
+	if e.Device != "" {
+		log.Printf("[%s] Device %s %s notification", e.Connection, e.Device, e.Kind)
+	} else {
+		log.Printf("[%s] Connection %s notification", e.Connection, e.Kind)
+	}
+
+	w.mu.Lock()
+	for _, ch := range w.subscribers {
+		select {
+		case ch <- *e:
+		default:
+		}
+	}
+	w.mu.Unlock()
+
+	// Недоступный webhook не должен задерживать проверку соединений
+	if w.conf.Webhook != "" {
+		select {
+		case w.hooks <- e:
+		default:
+			log.Printf("[%s] Connection webhook queue is full, %s event dropped", e.Connection, e.Kind)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package connwatch
+
+import (
+	"encoding/json"
+	"io"
+	"modbus2prometheus/controller"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{
+			Event{Kind: EVENT_DOWN, Connection: "boiler", Devices: []string{"meter", "valve"},
+				Downtime: 95 * time.Second, Error: "request timed out"},
+			"Нет связи с boiler 1m35s\nУстройства: meter, valve\nПоследняя ошибка: request timed out",
+		},
+		{
+			Event{Kind: EVENT_DEGRADED, Connection: "boiler", Device: "valve", Devices: []string{"valve"}},
+			"Связь с устройством valve (boiler) нестабильна",
+		},
+		{
+			Event{Kind: EVENT_DOWN, Connection: "boiler", Device: "valve", Devices: []string{"valve"},
+				Downtime: time.Minute, Error: "exception 11"},
+			"Нет связи с устройством valve (boiler) 1m0s\nПоследняя ошибка: exception 11",
+		},
+		{
+			Event{Kind: EVENT_RECOVERED, Connection: "boiler", Device: "valve", Devices: []string{"valve"},
+				Downtime: 750 * time.Second},
+			"Связь с устройством valve (boiler) восстановлена, связи не было 12m30s",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := text(&tt.e); got != tt.want {
+			t.Errorf("text(%s %s/%s) =\n%s\nwant\n%s", tt.e.Kind, tt.e.Connection, tt.e.Device, got, tt.want)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got JsonEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("webhook body %s: %s", body, err)
+		}
+	}))
+	defer srv.Close()
+
+	ts := time.Date(2026, 10, 18, 8, 50, 38, 0, time.UTC)
+	e := &Event{
+		Kind:       EVENT_RECOVERED,
+		Connection: "boiler",
+		Url:        "tcp://192.168.1.200:502",
+		Device:     "valve",
+		Devices:    []string{"valve"},
+		State:      controller.CONN_UP,
+		Prev:       controller.CONN_DOWN,
+		Error:      "request timed out",
+		Downtime:   750 * time.Second,
+		Time:       ts,
+	}
+	e.Text = text(e)
+
+	w := &Watcher{conf: Config{Webhook: srv.URL}, client: srv.Client()}
+	if err := w.postWebhook(e); err != nil {
+		t.Fatal(err)
+	}
+
+	want := JsonEvent{
+		Event:           "recovered",
+		Connection:      "boiler",
+		Url:             "tcp://192.168.1.200:502",
+		Device:          "valve",
+		Devices:         []string{"valve"},
+		State:           "up",
+		Previous:        "down",
+		Error:           "request timed out",
+		Downtime:        "12m30s",
+		DowntimeSeconds: 750,
+		Time:            ts,
+		Text:            e.Text,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("webhook body =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+
+		// Первые запросы долгие и с ошибкой, как у недоступного сервера
+		if n <= 2 {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ctrl, err := controller.New(&controller.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(Config{Webhook: srv.URL, RetryDelay: 10 * time.Millisecond, Ctrl: ctrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	e := &Event{Kind: EVENT_DOWN, Connection: "boiler", State: controller.CONN_DOWN, Time: time.Now()}
+	e.Text = text(e)
+	begin := time.Now()
+	w.publish(e)
+	if d := time.Since(begin); d > 50*time.Millisecond {
+		t.Errorf("publish took %s, webhook must not block the check loop", d)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		n := requests
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook got %d requests, want 3", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Успешная отправка больше не повторяется
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 {
+		t.Errorf("webhook got %d requests, want 3", requests)
+	}
+}
@@ -0,0 +1,114 @@
+package connwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// JsonEvent Тело запроса webhook
+type JsonEvent struct {
+	Event           string    `json:"event"`
+	Connection      string    `json:"connection"`
+	Url             string    `json:"url"`
+	Device          string    `json:"device,omitempty"`
+	Devices         []string  `json:"devices"`
+	State           string    `json:"state"`
+	Previous        string    `json:"previous"`
+	Error           string    `json:"error,omitempty"`
+	Downtime        string    `json:"downtime,omitempty"`
+	DowntimeSeconds float64   `json:"downtime_seconds,omitempty"`
+	Time            time.Time `json:"time"`
+	Text            string    `json:"text"`
+}
+
+// runWebhook Отправляет события из очереди по одному, с повторами после ошибок
+func (w *Watcher) runWebhook() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			if n := len(w.hooks); n > 0 {
+				log.Printf("Connection webhook: %d events not sent", n)
+			}
+			return
+		case e := <-w.hooks:
+			w.sendWebhook(e)
+		}
+	}
+}
+
+// sendWebhook Отправляет событие, после ошибки повторяет Retries раз с растущей паузой
+func (w *Watcher) sendWebhook(e *Event) {
+	delay := w.conf.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := w.postWebhook(e)
+		if err == nil {
+			return
+		}
+		if attempt >= w.conf.Retries {
+			log.Printf("Connection webhook error: %s, %s event dropped", err.Error(), e.Kind)
+			return
+		}
+		log.Printf("Connection webhook error: %s, retry in %s", err.Error(), delay)
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// postWebhook Отправляет событие POST-ом в JSON
+func (w *Watcher) postWebhook(e *Event) error {
+	body, err := json.Marshal(JsonEvent{
+		Event:           e.Kind.String(),
+		Connection:      e.Connection,
+		Url:             e.Url,
+		Device:          e.Device,
+		Devices:         e.Devices,
+		State:           e.State.String(),
+		Previous:        e.Prev.String(),
+		Error:           e.Error,
+		Downtime:        durationString(e.Downtime),
+		DowntimeSeconds: e.Downtime.Seconds(),
+		Time:            e.Time,
+		Text:            e.Text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.conf.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "modbus2prometheus")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.Round(time.Second).String()
+}
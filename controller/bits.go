@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/simonvetter/modbus"
+	"strings"
+	"time"
+)
+
+// Типы, из которых можно выделять биты
+func bitsSupported(d DataType) bool {
+	switch d {
+	case TYPE_UINT16, TYPE_INT16, TYPE_UINT32, TYPE_INT32, TYPE_UINT64, TYPE_INT64:
+		return true
+	}
+	return false
+}
+
+// BitTagName Имя битового тега по умолчанию: "status.bit3"
+func BitTagName(parent string, bit uint8) string {
+	return fmt.Sprintf("%s.bit%d", parent, bit)
+}
+
+// addBitTag Проверка и привязка битового тега к родительскому регистру
+func (c *Controller) addBitTag(tag *Tag) error {
+	parent := tag.Parent
+	if parent.controller != c {
+		return fmt.Errorf("tag %s: parent %s must be added first", tag.Name, parent.Name)
+	}
+	if parent.Expr != nil || parent.RegisterType.isBit() || !bitsSupported(parent.Type) {
+		return fmt.Errorf("tag %s: bits can not be taken from %s %s", tag.Name, parent.RegisterType, parent.Type)
+	}
+	if uint16(tag.Bit) >= 16*regCount(parent) {
+		return fmt.Errorf("tag %s: bit %d is out of %s", tag.Name, tag.Bit, parent.Type)
+	}
+	if Writable(tag) && parent.RegisterType != HOLDING_REGISTER {
+		return fmt.Errorf("tag %s: %s table is read only", tag.Name, parent.RegisterType)
+	}
+
+	tag.Device = parent.Device
+	tag.Address = parent.Address
+	tag.RegisterType = parent.RegisterType
+	tag.Type = TYPE_BOOL
+	tag.inheritLabels(parent)
+	parent.bits = append(parent.bits, tag)
+
+	return nil
+}
+
+// rawBits Целое значение регистра для выделения битов
+func rawBits(val interface{}) uint64 {
+	switch v := val.(type) {
+	case uint16:
+		return uint64(v)
+	case int16:
+		return uint64(uint16(v))
+	case uint32:
+		return uint64(v)
+	case int32:
+		return uint64(uint32(v))
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
+
+// updateBits Обновляет битовые теги по прочитанному значению родителя, без запросов к шине
+func (t *Tag) updateBits(raw interface{}) {
+	v := rawBits(raw)
+	for _, bit := range t.bits {
+		prev := bit.LastValue
+		bit.Action(v&(1<<bit.Bit) != 0, bit)
+		bit.LastRead = t.LastRead
+		bit.NextDue = t.NextDue
+		t.controller.notify(bit, prev, t.LastRead)
+	}
+}
+
+// writeBit Меняет один бит регистра чтением-модификацией-записью родительского регистра.
+// Вызывается с захваченной шиной, чтобы между чтением и записью не вклинился другой запрос.
+func (c *Connection) writeBit(tag *Tag, value float64) (err error) {
+	parent := tag.Parent
+	count := regCount(parent)
+	enc := parent.encoding()
+
+	start := time.Now()
+	regs, err := c.modbusClient.ReadRegisters(parent.Address, count, modbus.HOLDING_REGISTER)
+	c.observe(tag.Device, FC_READ_HOLDING_REGISTERS, []*Tag{parent}, start, err)
+	c.incCounter()
+	if err != nil {
+		return
+	}
+
+	v := joinRegs(enc.reorder(regs, parent.Type))
+	if value != 0 {
+		v |= 1 << tag.Bit
+	} else {
+		v &^= 1 << tag.Bit
+	}
+	regs = enc.reorder(splitRegs(v, count), parent.Type)
+
+	start = time.Now()
+	if len(regs) == 1 {
+		err = c.modbusClient.WriteRegister(parent.Address, regs[0])
+	} else {
+		err = c.modbusClient.WriteRegisters(parent.Address, regs)
+	}
+	c.observe(tag.Device, writeFunction(len(regs)), []*Tag{tag}, start, err)
+
+	return
+}
+
+// sanitizeMetricName Заменяет символы, недопустимые в имени метрики: "status.bit3" -> "status_bit3"
+func sanitizeMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"sort"
+)
+
+// block Диапазон регистров одной таблицы устройства, читается одним запросом
+type block struct {
+	regType RegisterType
+	address uint16
+	count   uint16
+	tags    []*Tag
+
+	// Результат чтения, regs для регистров, bits для coil и discrete input
+	regs []uint16
+	bits []bool
+}
+
+func (b *block) end() uint32 {
+	return uint32(b.address) + uint32(b.count)
+}
+
+// regCount Количество регистров, которое занимает значение тега
+func regCount(t *Tag) uint16 {
+	if t.RegisterType.isBit() {
+		return 1
+	}
+	return t.Type.regCount(t.Length)
+}
+
+// planBlocks Объединяет теги в блоки подряд идущих регистров. Между соседними тегами в блоке
+// может быть не больше maxGap непрочитанных регистров, размер блока не больше maxSize регистров.
+// Тег, который сам по себе больше maxSize, читается отдельным блоком.
+func planBlocks(tags []*Tag, maxGap uint16, maxSize uint16) (blocks []*block) {
+	sorted := make([]*Tag, 0, len(tags))
+	for _, tag := range tags {
+		if tag.Action != nil && tag.Expr == nil && tag.Parent == nil {
+			sorted = append(sorted, tag)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].RegisterType != sorted[j].RegisterType {
+			return sorted[i].RegisterType < sorted[j].RegisterType
+		}
+		return sorted[i].Address < sorted[j].Address
+	})
+
+	var cur *block
+	for _, tag := range sorted {
+		tagEnd := uint32(tag.Address) + uint32(regCount(tag))
+		if cur != nil && cur.regType == tag.RegisterType && uint32(tag.Address) <= cur.end()+uint32(maxGap) {
+			newEnd := cur.end()
+			if tagEnd > newEnd {
+				newEnd = tagEnd
+			}
+			if newEnd-uint32(cur.address) <= uint32(maxSize) {
+				cur.count = uint16(newEnd - uint32(cur.address))
+				cur.tags = append(cur.tags, tag)
+				continue
+			}
+		}
+
+		cur = &block{
+			regType: tag.RegisterType,
+			address: tag.Address,
+			count:   regCount(tag),
+			tags:    []*Tag{tag},
+		}
+		blocks = append(blocks, cur)
+	}
+
+	return
+}
+
+// decode Достает значение тега из прочитанных регистров блока
+func (b *block) decode(t *Tag) interface{} {
+	off := t.Address - b.address
+	if b.regType.isBit() {
+		return b.bits[off]
+	}
+	return t.Type.decode(t.encoding().reorder(b.regs[off:off+regCount(t)], t.Type))
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testTag(name string, regType RegisterType, address uint16, typ DataType) *Tag {
+	return &Tag{Name: name, RegisterType: regType, Address: address, Type: typ, Action: defaultAction}
+}
+
+// meterTags Holding-регистры счетчика без пропусков: 13 тегов uint16, int16 и float32
+// в диапазоне 513–528, последний float32 занимает регистры 527–528
+func meterTags() []*Tag {
+	return []*Tag{
+		testTag("u1", HOLDING_REGISTER, 513, TYPE_UINT16),
+		testTag("u2", HOLDING_REGISTER, 514, TYPE_UINT16),
+		testTag("p", HOLDING_REGISTER, 515, TYPE_FLOAT32),
+		testTag("i1", HOLDING_REGISTER, 517, TYPE_UINT16),
+		testTag("i2", HOLDING_REGISTER, 518, TYPE_UINT16),
+		testTag("i3", HOLDING_REGISTER, 519, TYPE_UINT16),
+		testTag("q", HOLDING_REGISTER, 520, TYPE_FLOAT32),
+		testTag("f", HOLDING_REGISTER, 522, TYPE_UINT16),
+		testTag("cos1", HOLDING_REGISTER, 523, TYPE_INT16),
+		testTag("cos2", HOLDING_REGISTER, 524, TYPE_INT16),
+		testTag("cos3", HOLDING_REGISTER, 525, TYPE_INT16),
+		testTag("status", HOLDING_REGISTER, 526, TYPE_UINT16),
+		testTag("energy", HOLDING_REGISTER, 527, TYPE_FLOAT32),
+	}
+}
+
+func TestPlanBlocks(t *testing.T) {
+	// Описание ожидаемого блока: таблица, адрес, количество регистров и имена тегов
+	type want struct {
+		regType RegisterType
+		address uint16
+		count   uint16
+		tags    []string
+	}
+
+	computed := testTag("sum", HOLDING_REGISTER, 0, TYPE_FLOAT64)
+	computed.Expr = &Expr{}
+	bit := testTag("status.bit3", HOLDING_REGISTER, 526, TYPE_BOOL)
+	bit.Parent = meterTags()[11]
+	noAction := testTag("write_only", HOLDING_REGISTER, 516, TYPE_UINT16)
+	noAction.Action = nil
+	str := testTag("serial", HOLDING_REGISTER, 600, TYPE_STRING)
+	str.Length = 20
+
+	tests := []struct {
+		name    string
+		tags    []*Tag
+		maxGap  uint16
+		maxSize uint16
+		want    []want
+	}{
+		{
+			name:    "meter 513-528 in one block",
+			tags:    meterTags(),
+			maxGap:  0,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 513, 16, []string{"u1", "u2", "p", "i1", "i2", "i3", "q", "f", "cos1", "cos2", "cos3", "status", "energy"}},
+			},
+		},
+		{
+			name:    "meter split by max size",
+			tags:    meterTags(),
+			maxGap:  0,
+			maxSize: 8,
+			want: []want{
+				{HOLDING_REGISTER, 513, 7, []string{"u1", "u2", "p", "i1", "i2", "i3"}},
+				{HOLDING_REGISTER, 520, 7, []string{"q", "f", "cos1", "cos2", "cos3", "status"}},
+				{HOLDING_REGISTER, 527, 2, []string{"energy"}},
+			},
+		},
+		{
+			name: "unsorted input",
+			tags: []*Tag{
+				testTag("c", HOLDING_REGISTER, 12, TYPE_UINT16),
+				testTag("a", HOLDING_REGISTER, 10, TYPE_UINT16),
+				testTag("b", HOLDING_REGISTER, 11, TYPE_UINT16),
+			},
+			maxGap:  0,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 10, 3, []string{"a", "b", "c"}},
+			},
+		},
+		{
+			name: "gap within limit",
+			tags: []*Tag{
+				testTag("a", HOLDING_REGISTER, 10, TYPE_UINT16),
+				testTag("b", HOLDING_REGISTER, 14, TYPE_UINT16),
+			},
+			maxGap:  3,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 10, 5, []string{"a", "b"}},
+			},
+		},
+		{
+			name: "gap over limit",
+			tags: []*Tag{
+				testTag("a", HOLDING_REGISTER, 10, TYPE_UINT16),
+				testTag("b", HOLDING_REGISTER, 15, TYPE_UINT16),
+			},
+			maxGap:  3,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 10, 1, []string{"a"}},
+				{HOLDING_REGISTER, 15, 1, []string{"b"}},
+			},
+		},
+		{
+			name: "gap counted after multi-register tag",
+			tags: []*Tag{
+				testTag("a", HOLDING_REGISTER, 10, TYPE_FLOAT64),
+				testTag("b", HOLDING_REGISTER, 16, TYPE_UINT16),
+			},
+			maxGap:  2,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 10, 7, []string{"a", "b"}},
+			},
+		},
+		{
+			name: "overlapping tags",
+			tags: []*Tag{
+				testTag("wide", HOLDING_REGISTER, 10, TYPE_UINT32),
+				testTag("low", HOLDING_REGISTER, 11, TYPE_UINT16),
+			},
+			maxGap:  0,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 10, 2, []string{"wide", "low"}},
+			},
+		},
+		{
+			name: "oversized tag in own block",
+			tags: []*Tag{
+				testTag("a", HOLDING_REGISTER, 598, TYPE_UINT16),
+				str,
+				testTag("b", HOLDING_REGISTER, 620, TYPE_UINT16),
+			},
+			maxGap:  5,
+			maxSize: 10,
+			want: []want{
+				{HOLDING_REGISTER, 598, 1, []string{"a"}},
+				{HOLDING_REGISTER, 600, 20, []string{"serial"}},
+				{HOLDING_REGISTER, 620, 1, []string{"b"}},
+			},
+		},
+		{
+			name: "mixed tables are not merged",
+			tags: []*Tag{
+				testTag("coil", COIL, 1, TYPE_BOOL),
+				testTag("input", INPUT_REGISTER, 1, TYPE_UINT16),
+				testTag("holding", HOLDING_REGISTER, 1, TYPE_UINT16),
+				testTag("discrete", DISCRETE_INPUT, 2, TYPE_BOOL),
+				testTag("input2", INPUT_REGISTER, 2, TYPE_UINT16),
+				testTag("coil2", COIL, 2, TYPE_BOOL),
+			},
+			maxGap:  10,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 1, 1, []string{"holding"}},
+				{INPUT_REGISTER, 1, 2, []string{"input", "input2"}},
+				{COIL, 1, 2, []string{"coil", "coil2"}},
+				{DISCRETE_INPUT, 2, 1, []string{"discrete"}},
+			},
+		},
+		{
+			name: "computed, bit and write-only tags skipped",
+			tags: []*Tag{
+				computed,
+				bit,
+				noAction,
+				testTag("status", HOLDING_REGISTER, 526, TYPE_UINT16),
+			},
+			maxGap:  0,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 526, 1, []string{"status"}},
+			},
+		},
+		{
+			name: "end of address space",
+			tags: []*Tag{
+				testTag("a", HOLDING_REGISTER, 65533, TYPE_UINT16),
+				testTag("b", HOLDING_REGISTER, 65534, TYPE_UINT16),
+			},
+			maxGap:  10,
+			maxSize: 125,
+			want: []want{
+				{HOLDING_REGISTER, 65533, 2, []string{"a", "b"}},
+			},
+		},
+		{
+			name:    "no tags",
+			tags:    nil,
+			maxGap:  0,
+			maxSize: 125,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []want
+			for _, b := range planBlocks(tt.tags, tt.maxGap, tt.maxSize) {
+				w := want{regType: b.regType, address: b.address, count: b.count}
+				for _, tag := range b.tags {
+					w.tags = append(w.tags, tag.Name)
+				}
+				got = append(got, w)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planBlocks() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// resolveVar Ищет тег, на который ссылается выражение: сначала на том же устройстве, потом везде
+func (c *Controller) resolveVar(tag *Tag, name string) *Tag {
+	if dep := tag.Device.FindTag(name); dep != nil {
+		return dep
+	}
+
+	return c.FindTag(name)
+}
+
+// ResolveComputed Связывает вычисляемые теги с тегами из выражений и определяет порядок вычисления.
+// Вызывается после добавления всех тегов, возвращает ошибку при неизвестном теге или циклической зависимости.
+func (c *Controller) ResolveComputed() error {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, tag := range c.tags {
+		if tag.Expr == nil {
+			continue
+		}
+
+		tag.deps = make(map[string]*Tag)
+		for _, name := range tag.Expr.Vars() {
+			dep := c.resolveVar(tag, name)
+			if dep == nil {
+				return fmt.Errorf("tag %s: unknown tag %s in expr %s", tag.FullName(), name, tag.Expr)
+			}
+			tag.deps[name] = dep
+		}
+	}
+
+	// Топологическая сортировка обходом в глубину
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[*Tag]int)
+	var order []*Tag
+	var path []string
+
+	var visit func(tag *Tag) error
+	visit = func(tag *Tag) error {
+		switch state[tag] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("cyclic dependency in computed tags: %s -> %s", strings.Join(path, " -> "), tag.FullName())
+		}
+
+		state[tag] = visiting
+		path = append(path, tag.FullName())
+		for _, dep := range tag.deps {
+			if dep.Expr != nil {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[tag] = done
+		order = append(order, tag)
+
+		return nil
+	}
+
+	for _, tag := range c.tags {
+		if tag.Expr != nil {
+			if err := visit(tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.computed = order
+
+	return nil
+}
+
+// evalComputed Пересчитывает вычисляемые теги, вызывается после каждого цикла опроса
+func (c *Controller) evalComputed() {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for _, tag := range c.computed {
+		val, err := tag.Expr.Eval(func(name string) (float64, bool) {
+			// Устаревшие значения не используем, вычисляемый тег тогда тоже устареет
+			dep := tag.deps[name]
+			if dep == nil || dep.Quality(now) != QUALITY_GOOD {
+				return 0, false
+			}
+			return toFloat(dep.LastValue)
+		})
+
+		// Ошибку пишем в лог только при её появлении, чтобы не засорять лог каждый цикл
+		if err != nil {
+			tag.LastError = err.Error()
+			tag.LastErrorTime = now
+			if tag.exprErr != err.Error() {
+				log.Printf("Computed tag %s error: %s", tag.FullName(), err.Error())
+				tag.exprErr = err.Error()
+			}
+			continue
+		}
+		tag.exprErr = ""
+
+		prev := tag.LastValue
+		tag.Action(val, tag)
+		tag.markRead(now)
+		c.notify(tag, prev, now)
+	}
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testController Контроллер с устройствами boiler и floor на одном соединении без опроса.
+// Теги задаются как "устройство/имя" и выражение, у тегов без выражения значение value.
+func testController(t *testing.T, tags [][2]string, value float64) *Controller {
+	c := &Controller{}
+	conn := &Connection{controller: c}
+	for _, name := range []string{"boiler", "floor"} {
+		conn.devices = append(conn.devices, &Device{Name: name, conn: conn})
+	}
+	c.connections = []*Connection{conn}
+
+	now := time.Now()
+	for _, def := range tags {
+		devName, name, _ := strings.Cut(def[0], "/")
+		tag := &Tag{
+			Name:       name,
+			Device:     c.FindDevice(devName),
+			StaleAfter: time.Hour,
+			Action:     func(val interface{}, t *Tag) { t.LastValue = val },
+		}
+		if def[1] != "" {
+			expr, err := ParseExpr(def[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			tag.Expr = expr
+		} else {
+			tag.LastValue = value
+			tag.LastRead = now
+		}
+		tag.Device.tags = append(tag.Device.tags, tag)
+		c.tags = append(c.tags, tag)
+	}
+
+	return c
+}
+
+func TestResolveComputed(t *testing.T) {
+	tests := []struct {
+		name string
+		tags [][2]string
+		err  string
+	}{
+		{
+			name: "chain declared in reverse order",
+			tags: [][2]string{
+				{"boiler/c", "b + 1"},
+				{"boiler/b", "a * 2"},
+				{"boiler/a", ""},
+			},
+		},
+		{
+			name: "other device",
+			tags: [][2]string{
+				{"boiler/a", ""},
+				{"floor/sum", "{boiler/a} + a"},
+				{"floor/a", ""},
+			},
+		},
+		{
+			name: "unknown tag",
+			tags: [][2]string{
+				{"boiler/a", "nope + 1"},
+			},
+			err: "tag boiler/a: unknown tag nope",
+		},
+		{
+			name: "unknown device",
+			tags: [][2]string{
+				{"boiler/a", "{pump/a}"},
+			},
+			err: "unknown tag pump/a",
+		},
+		{
+			name: "self reference",
+			tags: [][2]string{
+				{"boiler/a", "a + 1"},
+			},
+			err: "cyclic dependency in computed tags: boiler/a -> boiler/a",
+		},
+		{
+			name: "cycle across devices",
+			tags: [][2]string{
+				{"boiler/a", "b"},
+				{"boiler/b", "{floor/c} * 2"},
+				{"floor/c", "{boiler/a} - 1"},
+			},
+			err: "cyclic dependency in computed tags: boiler/a -> boiler/b -> floor/c -> boiler/a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testController(t, tt.tags, 1).ResolveComputed()
+			if tt.err == "" && err != nil {
+				t.Errorf("ResolveComputed() error: %s", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("ResolveComputed() error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEvalComputed(t *testing.T) {
+	c := testController(t, [][2]string{
+		{"boiler/c", "b + 1"},
+		{"boiler/b", "a * 2"},
+		{"boiler/a", ""},
+		{"floor/a", ""},
+		{"floor/d", "{boiler/c} / (a - 5)"},
+		{"floor/e", "d + 1"},
+	}, 5)
+	if err := c.ResolveComputed(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Зависимости вычисляются раньше зависящих от них тегов
+	c.evalComputed()
+	if v := c.FindTag("boiler/c").LastValue; v != float64(11) {
+		t.Errorf("boiler/c = %v, want 11", v)
+	}
+
+	// Ошибка вычисления не меняет значение, зависящие теги остаются без значения
+	d, e := c.FindTag("floor/d"), c.FindTag("floor/e")
+	if d.LastValue != nil || !strings.Contains(d.LastError, "division by zero") {
+		t.Errorf("floor/d = %v, error %q, want division by zero", d.LastValue, d.LastError)
+	}
+	if e.LastValue != nil || !strings.Contains(e.LastError, "no value for d") {
+		t.Errorf("floor/e = %v, error %q, want no value for d", e.LastValue, e.LastError)
+	}
+}
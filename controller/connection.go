@@ -0,0 +1,480 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/mcuadros/go-defaults"
+	"github.com/simonvetter/modbus"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Ограничение протокола на количество регистров в одном запросе чтения
+const maxReadRegisters = 125
+
+// ConnectionConfiguration Настройки одного соединения (шлюза или порта) с шиной modbus
+type ConnectionConfiguration struct {
+	Name         string
+	Url          string
+	DeviceId     uint8         `default:"16"`
+	Speed        uint          `default:"19200"`
+	Timeout      time.Duration `default:"1s"`
+	PollingTime  time.Duration `default:"1s"`
+	ReadPeriod   time.Duration `default:"20ms"`
+	ErrTimeout   time.Duration `default:"500ms"` // Начальная задержка переподключения, удваивается до MaxBackoff
+	MaxBackoff   time.Duration `default:"1m"`
+	MaxAttempts  uint          `default:"20"` // После стольких ошибок подряд соединение считается упавшим
+	MaxGap       uint16        // Сколько непрочитанных регистров допускается между тегами в одном блоке
+	MaxBlock     uint16        `default:"64"`
+	WriteTimeout time.Duration `default:"5s"` // Сколько запрос записи может ждать в очереди и выполняться
+	StaleAfter   time.Duration `default:"1m"` // Через сколько без успешного чтения значение тега устаревает
+}
+
+// ConnState Состояние соединения
+type ConnState uint8
+
+const (
+	CONN_CONNECTING ConnState = iota
+	CONN_UP
+	CONN_DEGRADED // Были ошибки, но меньше MaxAttempts подряд
+	CONN_DOWN
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case CONN_UP:
+		return "up"
+	case CONN_DEGRADED:
+		return "degraded"
+	case CONN_DOWN:
+		return "down"
+	}
+	return "connecting"
+}
+
+// Connection Соединение с шиной, у каждого соединения свой полер, переподключение и счетчики ошибок.
+// Все обращения к шине выполняются горутиной полера, записи передаются ей через очередь writes.
+type Connection struct {
+	conf         ConnectionConfiguration
+	controller   *Controller
+	modbusClient *modbus.ModbusClient
+	devices      []*Device
+	needRestart  bool
+	open         bool // Клиент открыт, меняется только горутиной полера
+	writes       chan *writeRequest
+	state        ConnState
+	stateSince   time.Time // Время последней смены состояния
+	lastErr      error
+
+	// metrics
+	errCounter       *metrics.Counter
+	reqCounter       *metrics.Counter
+	reconnectCounter *metrics.Counter
+}
+
+func newConnection(ctrl *Controller, conf *ConnectionConfiguration) (c *Connection, err error) {
+	defaults.SetDefaults(conf)
+	if conf.Name == "" {
+		conf.Name = conf.Url
+	}
+	if conf.MaxBlock > maxReadRegisters {
+		conf.MaxBlock = maxReadRegisters
+	}
+
+	c = &Connection{
+		conf:       *conf,
+		controller: ctrl,
+		writes:     make(chan *writeRequest, writeQueueSize),
+		stateSince: time.Now(),
+	}
+
+	// Создаем метрики
+	c.reqCounter = metrics.NewCounter(fmt.Sprintf("req_counter{connection=%q}", c.conf.Name))
+	c.errCounter = metrics.NewCounter(fmt.Sprintf("err_counter{connection=%q}", c.conf.Name))
+	c.reconnectCounter = metrics.NewCounter(fmt.Sprintf("modbus_reconnects_total{connection=%q}", c.conf.Name))
+	metrics.NewGauge(fmt.Sprintf("modbus_connection_up{connection=%q}", c.conf.Name), func() float64 {
+		if state, _ := c.State(); state == CONN_UP || state == CONN_DEGRADED {
+			return 1
+		}
+		return 0
+	})
+
+	// for an RTU over TCP device/bus (remote serial port or
+	// simple TCP-to-serial bridge)
+	c.modbusClient, err = modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     c.conf.Url,
+		Speed:   c.conf.Speed, // serial link speed
+		Timeout: c.conf.Timeout,
+	})
+	if err != nil {
+		return
+	}
+
+	// Недоступный при старте шлюз не мешает остальным соединениям, переподключимся при опросе
+	err = c.modbusClient.Open()
+	if err != nil {
+		log.Printf("[%s] Can not open connect: %s", c.conf.Name, err.Error())
+		c.needRestart = true
+		err = nil
+	}
+	c.open = !c.needRestart
+
+	return
+}
+
+func (c *Connection) Name() string {
+	return c.conf.Name
+}
+
+func (c *Connection) Url() string {
+	return c.conf.Url
+}
+
+func (c *Connection) Devices() []*Device {
+	return c.devices
+}
+
+// AddDevice Добавляет устройство с адресом unitId на шину соединения
+func (c *Connection) AddDevice(name string, unitId uint8) (dev *Device, err error) {
+	c.controller.Lock()
+	defer c.controller.Unlock()
+
+	return c.addDevice(name, unitId)
+}
+
+func (c *Connection) addDevice(name string, unitId uint8) (*Device, error) {
+	if name == "" {
+		name = strconv.Itoa(int(unitId))
+	}
+
+	if c.controller.FindDevice(name) != nil {
+		return nil, fmt.Errorf("device %s already exists", name)
+	}
+
+	dev := &Device{Name: name, UnitId: unitId, conn: c, stateSince: time.Now()}
+	c.devices = append(c.devices, dev)
+	metrics.NewGauge(fmt.Sprintf("modbus_device_up{connection=%q,device=%q}", c.conf.Name, name), func() float64 {
+		if state, _ := dev.State(); state == CONN_UP || state == CONN_DEGRADED {
+			return 1
+		}
+		return 0
+	})
+
+	return dev, nil
+}
+
+func (c *Connection) defaultDevice() (*Device, error) {
+	for i, dev := range c.devices {
+		if dev.UnitId == c.conf.DeviceId {
+			return c.devices[i], nil
+		}
+	}
+
+	return c.addDevice("", c.conf.DeviceId)
+}
+
+func (c *Connection) incCounter() {
+	c.reqCounter.Inc()
+}
+
+func (c *Connection) incErrCounter() {
+	c.errCounter.Inc()
+}
+
+// readBlock Чтение блока регистров устройства одним запросом
+func (c *Connection) readBlock(dev *Device, blk *block) (err error) {
+	// Адрес устройства выставляем на каждый запрос, т.к. соединение общее для всех устройств
+	err = c.modbusClient.SetUnitId(dev.UnitId)
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	switch blk.regType {
+	case HOLDING_REGISTER:
+		blk.regs, err = c.modbusClient.ReadRegisters(blk.address, blk.count, modbus.HOLDING_REGISTER)
+	case INPUT_REGISTER:
+		blk.regs, err = c.modbusClient.ReadRegisters(blk.address, blk.count, modbus.INPUT_REGISTER)
+	case COIL:
+		blk.bits, err = c.modbusClient.ReadCoils(blk.address, blk.count)
+	case DISCRETE_INPUT:
+		blk.bits, err = c.modbusClient.ReadDiscreteInputs(blk.address, blk.count)
+	}
+	c.observe(dev, readFunction(blk.regType), blk.tags, start, err)
+	c.incCounter()
+
+	return
+}
+
+// execWrite Запись значения тега в шину, выполняется горутиной полера
+func (c *Connection) execWrite(tag *Tag, value float64) (err error) {
+	err = c.modbusClient.SetUnitId(tag.Device.UnitId)
+	if err != nil {
+		return
+	}
+
+	// Пробуем записать
+	if tag.Parent != nil {
+		err = c.writeBit(tag, value)
+		return
+	}
+	if tag.RegisterType == COIL {
+		start := time.Now()
+		err = c.modbusClient.WriteCoil(tag.Address, value != 0)
+		c.observe(tag.Device, FC_WRITE_SINGLE_COIL, []*Tag{tag}, start, err)
+		return
+	}
+
+	regs, err := tag.Type.encode(tag.toRaw(value))
+	if err != nil {
+		return
+	}
+	regs = tag.encoding().reorder(regs, tag.Type)
+
+	// Одиночный регистр пишем функцией 0x06, её поддерживают все устройства
+	start := time.Now()
+	if len(regs) == 1 {
+		err = c.modbusClient.WriteRegister(tag.Address, regs[0])
+	} else {
+		err = c.modbusClient.WriteRegisters(tag.Address, regs)
+	}
+	c.observe(tag.Device, writeFunction(len(regs)), []*Tag{tag}, start, err)
+
+	return
+}
+
+// pollDevice Опрос тегов устройства, которым пора читаться, соседние теги читаются блоками.
+// Возвращает число запросов, на которые устройство ответило (в том числе исключением).
+// После исключения опрос устройства продолжается со следующего блока, после таймаута или
+// ошибки CRC теги устройства, оставшиеся в этом цикле, отмечаются ошибкой.
+func (c *Connection) pollDevice(ctx context.Context, dev *Device) (answered int, err error) {
+	var due []*Tag
+	now := time.Now()
+	for _, tag := range dev.tags {
+		if tag.due(now) {
+			due = append(due, tag)
+		}
+	}
+
+	blocks := planBlocks(due, c.conf.MaxGap, c.conf.MaxBlock)
+	for i, blk := range blocks {
+		if !c.wait(ctx, c.conf.ReadPeriod) {
+			return answered, ctx.Err()
+		}
+
+		// Записи выполняются раньше чтений
+		c.drainWrites()
+
+		err = c.readBlock(dev, blk)
+		if err == nil {
+			answered++
+			c.store(blk)
+			continue
+		}
+
+		// Обработка ошибок
+		c.incErrCounter()
+		log.Printf("[%s] Req %d error get %s %d-%d of %s err: %s",
+			c.conf.Name, c.reqCounter.Get(), blk.regType, blk.address, blk.end()-1, dev.Name, err.Error())
+
+		failed := blocks[i : i+1]
+		switch {
+		case isException(err):
+			answered++
+		case !transportError(err):
+			// Устройство не отвечает, не тратим время шины на остальные его блоки
+			failed = blocks[i:]
+		}
+
+		c.controller.Lock()
+		now := time.Now()
+		for _, b := range failed {
+			for _, tag := range b.tags {
+				tag.markError(err, now)
+			}
+		}
+		c.controller.Unlock()
+
+		if !isException(err) {
+			return answered, err
+		}
+	}
+
+	return answered, nil
+}
+
+// store Сохраняет значения тегов прочитанного блока
+func (c *Connection) store(blk *block) {
+	c.controller.Lock()
+	defer c.controller.Unlock()
+
+	now := time.Now()
+	for _, tag := range blk.tags {
+		raw := blk.decode(tag)
+		prev := tag.LastValue
+		tag.Action(tag.fromRaw(raw), tag)
+		tag.markRead(now)
+		c.controller.notify(tag, prev, now)
+		tag.updateBits(raw)
+	}
+}
+
+// State Текущее состояние соединения и последняя ошибка
+func (c *Connection) State() (ConnState, error) {
+	c.controller.RLock()
+	defer c.controller.RUnlock()
+
+	return c.state, c.lastErr
+}
+
+// StateSince Время последней смены состояния соединения
+func (c *Connection) StateSince() time.Time {
+	c.controller.RLock()
+	defer c.controller.RUnlock()
+
+	return c.stateSince
+}
+
+// setState Меняет состояние соединения, переходы пишутся в лог
+func (c *Connection) setState(state ConnState, err error) {
+	c.controller.Lock()
+	prev := c.state
+	c.state = state
+	c.lastErr = err
+	if prev != state {
+		c.stateSince = time.Now()
+	}
+	c.controller.Unlock()
+
+	if prev == state {
+		return
+	}
+
+	switch state {
+	case CONN_UP:
+		log.Printf("[%s] Connection is up", c.conf.Name)
+	case CONN_DEGRADED:
+		log.Printf("[%s] Connection is degraded: %v", c.conf.Name, err)
+	case CONN_DOWN:
+		log.Printf("[%s] Connection is down after %d attempts: %v", c.conf.Name, c.conf.MaxAttempts, err)
+	}
+}
+
+// reopen Переоткрывает соединение с шиной
+func (c *Connection) reopen() (err error) {
+	err = c.modbusClient.Open()
+	c.open = err == nil
+	return
+}
+
+// close Закрывает соединение с шиной
+func (c *Connection) close() (err error) {
+	if c.open {
+		err = c.modbusClient.Close()
+		c.open = false
+	}
+	return
+}
+
+// pollCycle Один цикл опроса всех устройств. Ошибка одного устройства не мешает опросу
+// остальных, цикл прерывается только на ошибке самого соединения. Если не ответило
+// ни одно устройство, скорее всего недоступен шлюз, тогда возвращается последняя ошибка.
+func (c *Connection) pollCycle(ctx context.Context) error {
+	var answered int
+	var lastErr error
+	for _, dev := range c.devices {
+		n, err := c.pollDevice(ctx, dev)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		answered += n
+		if err != nil && transportError(err) {
+			return err
+		}
+
+		// Состояние устройства меняется, только если в этом цикле к нему были запросы
+		switch {
+		case err != nil:
+			dev.failed(err)
+			lastErr = err
+		case n > 0:
+			dev.answered()
+		}
+	}
+
+	if answered == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// Poll Опрос соединения до остановки контекста. При ошибках соединение переоткрывается
+// с экспоненциально растущей задержкой, после MaxAttempts ошибок подряд соединение
+// переходит в состояние down, но попытки переподключения продолжаются.
+func (c *Connection) Poll(ctx context.Context) {
+	log.Printf("[%s] Start polling...", c.conf.Name)
+
+	var failAttempts uint = 0
+	backoff := c.conf.ErrTimeout
+	needRestart := c.needRestart
+
+	fail := func(err error) {
+		failAttempts += 1
+		state := c.state
+		if failAttempts >= c.conf.MaxAttempts {
+			state = CONN_DOWN
+		} else if state == CONN_UP {
+			state = CONN_DEGRADED
+		}
+		c.setState(state, err)
+
+		// Задержка нужна еще и чтобы сломанный пакет протух
+		log.Printf("[%s] Retry in %s", c.conf.Name, backoff)
+		c.wait(ctx, backoff)
+		backoff *= 2
+		if backoff > c.conf.MaxBackoff {
+			backoff = c.conf.MaxBackoff
+		}
+	}
+
+	for ctx.Err() == nil {
+		// Принудительный рестарт
+		if needRestart {
+			log.Printf("[%s] Restarting connect...", c.conf.Name)
+			c.reconnectCounter.Inc()
+			err := c.reopen()
+			if err != nil {
+				log.Printf("[%s] Can not open connect: %s", c.conf.Name, err.Error())
+				fail(err)
+				continue
+			}
+			needRestart = false
+		}
+
+		err := c.pollCycle(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			needRestart = true
+			c.close()
+			fail(err)
+			continue
+		}
+
+		// Сбрасываем счетчик попыток
+		failAttempts = 0
+		backoff = c.conf.ErrTimeout
+		c.setState(CONN_UP, nil)
+
+		c.controller.evalComputed()
+		c.wait(ctx, c.conf.PollingTime)
+	}
+
+	log.Printf("[%s] End polling", c.conf.Name)
+	if err := c.close(); err != nil {
+		log.Printf("[%s] Connection close error: %s", c.conf.Name, err.Error())
+	}
+}
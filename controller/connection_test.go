@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddConnectionDuplicate(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  ConnectionConfiguration
+		second ConnectionConfiguration
+		err    string
+	}{
+		{"same name",
+			ConnectionConfiguration{Name: "t02a", Url: "tcp://127.0.0.1:1"},
+			ConnectionConfiguration{Name: "t02a", Url: "tcp://127.0.0.1:2"},
+			"connection t02a already exists"},
+		{"same url without name",
+			ConnectionConfiguration{Url: "tcp://127.0.0.1:3"},
+			ConnectionConfiguration{Url: "tcp://127.0.0.1:3"},
+			"connection tcp://127.0.0.1:3 already exists"},
+		{"name equals url",
+			ConnectionConfiguration{Url: "tcp://127.0.0.1:4"},
+			ConnectionConfiguration{Name: "tcp://127.0.0.1:4", Url: "tcp://127.0.0.1:5"},
+			"connection tcp://127.0.0.1:4 already exists"},
+		{"same url with names",
+			ConnectionConfiguration{Name: "t02b", Url: "tcp://127.0.0.1:6"},
+			ConnectionConfiguration{Name: "t02c", Url: "tcp://127.0.0.1:6"},
+			""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(&Configuration{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Порты закрыты, соединение откроется при опросе, который здесь не запускается
+			tt.first.Timeout, tt.second.Timeout = 100*time.Millisecond, 100*time.Millisecond
+
+			if _, err := c.AddConnection(&tt.first); err != nil {
+				t.Fatalf("AddConnection(%s) error: %s", tt.first.Url, err)
+			}
+			_, err = c.AddConnection(&tt.second)
+			if tt.err == "" && err != nil {
+				t.Errorf("AddConnection(%s) error: %s", tt.second.Url, err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("AddConnection(%s) error %v, want %q", tt.second.Url, err, tt.err)
+			}
+			want := 2
+			if tt.err != "" {
+				want = 1
+			}
+			if got := len(c.Connections()); got != want {
+				t.Errorf("%d connections, want %d", got, want)
+			}
+		})
+	}
+}
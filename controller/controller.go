@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"context"
+	"fmt"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/mcuadros/go-defaults"
-	"github.com/simonvetter/modbus"
 	"log"
-	"os"
+	"math"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,6 +19,10 @@ const (
 	READ_FLOAT  = 0x2
 	WRITE_UINT  = 0x4
 	WRITE_FLOAT = 0x8
+	READ_BOOL   = 0x10
+	WRITE_BOOL  = 0x20
+	READ        = 0x40 // Чтение значения типа Tag.Type
+	WRITE       = 0x80 // Запись значения типа Tag.Type
 )
 
 type logger struct {
@@ -24,62 +30,115 @@ type logger struct {
 	customLogger *log.Logger
 }
 
+// Configuration Общие настройки контроллера, настройки шины задаются для каждого соединения отдельно
 type Configuration struct {
-	DeviceId    uint8 `default:"16"`
-	Url         string
-	Speed       uint          `default:"19200"`
-	Timeout     time.Duration `default:"1s"`
-	PollingTime time.Duration `default:"1s"`
-	ReadPeriod  time.Duration `default:"20ms"`
-	ErrTimeout  time.Duration `default:"500ms"`
-	MaxAttempts uint          `default:"20"`
+	MaxAttempts  uint   `default:"20"`
+	MetricPrefix string // Префикс имен метрик тегов
+	MetricFamily string // Если задано, все теги пишутся в одну метрику с этим именем и меткой tag
 }
 
+// Controller Пул соединений с шинами modbus, единая точка доступа к тегам всех устройств
 type Controller struct {
-	sync.RWMutex
+	sync.RWMutex // Защищает значения тегов
 	conf         Configuration
 	logger       *logger
-	modbusClient *modbus.ModbusClient
+	connections  []*Connection
 	tags         []*Tag
-	exit         bool
+	computed     []*Tag          // Вычисляемые теги в порядке вычисления
+	metricTags   map[string]*Tag // Зарегистрированные метрики тегов и их теги
+	subscribers  []chan Update
 
-	// metrics
-	errCounter *metrics.Counter
-	reqCounter *metrics.Counter
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 func New(conf *Configuration) (c *Controller, err error) {
 	defaults.SetDefaults(conf)
+	if conf.MetricPrefix != "" && !validMetricName(conf.MetricPrefix) {
+		return nil, fmt.Errorf("bad metric prefix %q, must match [a-zA-Z_:][a-zA-Z0-9_:]*", conf.MetricPrefix)
+	}
+	if conf.MetricFamily != "" && !validMetricName(conf.MetricFamily) {
+		return nil, fmt.Errorf("bad metric family %q, must match [a-zA-Z_:][a-zA-Z0-9_:]*", conf.MetricFamily)
+	}
 	c = &Controller{
-		conf: *conf,
+		conf:       *conf,
+		metricTags: make(map[string]*Tag),
 	}
 
-	// Создаем метрики
-	c.reqCounter = metrics.NewCounter("req_counter")
-	c.errCounter = metrics.NewCounter("err_counter")
+	return
+}
 
-	// for an RTU over TCP device/bus (remote serial port or
-	// simple TCP-to-serial bridge)
-	c.modbusClient, err = modbus.NewClient(&modbus.ClientConfiguration{
-		URL:     c.conf.Url,
-		Speed:   c.conf.Speed, // serial link speed
-		Timeout: c.conf.Timeout,
-	})
-	if err != nil {
-		return
+// AddConnection Добавляет соединение с шиной, опрос начнется после вызова Poll
+func (c *Controller) AddConnection(conf *ConnectionConfiguration) (conn *Connection, err error) {
+	if conf.MaxAttempts == 0 {
+		conf.MaxAttempts = c.conf.MaxAttempts
 	}
 
-	err = c.modbusClient.SetUnitId(c.conf.DeviceId)
+	// Имя соединения входит в метки метрик, повтор нельзя зарегистрировать
+	name := conf.Name
+	if name == "" {
+		name = conf.Url
+	}
+	if c.FindConnection(name) != nil {
+		return nil, fmt.Errorf("connection %s already exists", name)
+	}
+
+	conn, err = newConnection(c, conf)
 	if err != nil {
 		return
 	}
 
-	err = c.modbusClient.Open()
+	c.Lock()
+	c.connections = append(c.connections, conn)
+	c.Unlock()
+
+	return
+}
+
+func (c *Controller) Connections() []*Connection {
+	return c.connections
+}
+
+func (c *Controller) FindConnection(name string) *Connection {
+	for i, conn := range c.connections {
+		if conn.conf.Name == name {
+			return c.connections[i]
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) Devices() (devices []*Device) {
+	for _, conn := range c.connections {
+		devices = append(devices, conn.devices...)
+	}
 
 	return
 }
 
+func (c *Controller) FindDevice(name string) *Device {
+	for _, conn := range c.connections {
+		for i, dev := range conn.devices {
+			if dev.Name == name {
+				return conn.devices[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindTag Ищет тег по имени, имя может быть указано вместе с устройством: "device/name"
 func (c *Controller) FindTag(name string) *Tag {
+	if devName, tagName, found := strings.Cut(name, "/"); found {
+		dev := c.FindDevice(devName)
+		if dev == nil {
+			return nil
+		}
+		return dev.FindTag(tagName)
+	}
+
 	for i, tag := range c.tags {
 		if tag.Name == name {
 			return c.tags[i]
@@ -93,128 +152,172 @@ func (c *Controller) Tags() []*Tag {
 	return c.tags
 }
 
-func (c *Controller) AddTag(tag *Tag) {
+// AddTag Добавляет тег, если устройство не указано, то тег попадет на устройство DeviceId первого соединения
+func (c *Controller) AddTag(tag *Tag) (err error) {
 	c.Lock()
 	defer c.Unlock()
 
-	tag.Gauge = metrics.NewGauge(tag.Name, func() float64 {
-		c.RLock()
-		defer c.RUnlock()
-		if tag.LastValue != nil {
-			if isUint(tag) {
-				return float64(tag.LastValue.(uint16))
-			} else if isFloat(tag) {
-				return float64(tag.LastValue.(float32))
-			}
+	if tag.Parent != nil {
+		if err = c.addBitTag(tag); err != nil {
+			return
 		}
-		return 0.0
-	})
-
-	if tag.Action == nil {
-		if isUint(tag) {
-			tag.Action = defaultUint16Action
-		} else if isFloat(tag) {
-			tag.Action = defaultFloat32Action
+	} else if tag.Device == nil {
+		if len(c.connections) == 0 {
+			return fmt.Errorf("no connections for tag %s", tag.Name)
+		}
+		tag.Device, err = c.connections[0].defaultDevice()
+		if err != nil {
+			return
+		}
+	}
 
+	if tag.Expr != nil {
+		// Вычисляемый тег не связан с регистрами, значение всегда float64
+		tag.Type = TYPE_FLOAT64
+		if Writable(tag) {
+			return fmt.Errorf("tag %s: computed tag can not be written", tag.Name)
 		}
+	} else if tag.Type == TYPE_DEFAULT {
+		tag.Type = operationType(tag)
+	}
+	if tag.Parent != nil && tag.Expr != nil {
+		return fmt.Errorf("tag %s: bit tag can not be computed", tag.Name)
+	}
+	if tag.Expr == nil && tag.RegisterType.isBit() && tag.Type != TYPE_BOOL {
+		return fmt.Errorf("tag %s: %s table holds only bool values", tag.Name, tag.RegisterType)
+	}
+	if Writable(tag) && !tag.RegisterType.Writable() {
+		return fmt.Errorf("tag %s: %s table is read only", tag.Name, tag.RegisterType)
+	}
+	if Writable(tag) && !tag.Type.Numeric() {
+		return fmt.Errorf("tag %s: %s can not be written", tag.Name, tag.Type)
+	}
+	if tag.Verify && !(Readable(tag) || (tag.Parent != nil && tag.Parent.Action != nil)) {
+		return fmt.Errorf("tag %s: verify needs a readable tag", tag.Name)
 	}
-	tag.controller = c
 
-	c.tags = append(c.tags, tag)
-}
+	if err = tag.checkLabels(); err != nil {
+		return
+	}
 
-func (c *Controller) WriteTag(tag *Tag, value float64) (err error) {
-	// Пробуем записать
-	if isWriteUint(tag) {
-		err = c.modbusClient.WriteRegister(tag.Address, uint16(value))
-	} else if isWriteFloat(tag) {
-		err = c.modbusClient.WriteFloat32(tag.Address, float32(value))
+	// Имена всех метрик тега проверяем до регистрации, NewGauge паникует на занятом имени.
+	// Для тегов с названиями состояний добавляется enum метрика name_state{state="..."},
+	// описание тега отдается отдельной метрикой name_info{desc="..."}.
+	tag.Metric = c.metricName(tag, "")
+	states := make(map[string]string)
+	for _, label := range tag.Values {
+		states[label] = c.metricName(tag, "_state", fmt.Sprintf("state=%q", label))
+	}
+	var info string
+	if tag.DisplayName != "" {
+		info = c.metricName(tag, "_info", fmt.Sprintf("desc=%q", tag.DisplayName))
 	}
 
-	return
-}
+	var names []string
+	if tag.Type.Numeric() {
+		names = append(names, tag.Metric)
+	}
+	for _, name := range states {
+		names = append(names, name)
+	}
+	if info != "" {
+		names = append(names, info)
+	}
+	if err = c.reserveMetrics(tag, names); err != nil {
+		return
+	}
 
-func (c *Controller) Close() {
-	c.exit = true
-}
+	// Устаревшие и не прочитанные значения отдаем как NaN. У строк числового значения нет,
+	// метрика для них не создается.
+	if tag.Type.Numeric() {
+		tag.Gauge = metrics.NewGauge(tag.Metric, func() float64 {
+			c.RLock()
+			defer c.RUnlock()
+			if tag.Quality(time.Now()) != QUALITY_GOOD {
+				return math.NaN()
+			}
+			v, _ := toFloat(tag.LastValue)
+			return v
+		})
+	}
 
-func (c *Controller) incCounter() {
-	c.reqCounter.Inc()
-}
+	for label, name := range states {
+		label := label
+		metrics.NewGauge(name, func() float64 {
+			c.RLock()
+			defer c.RUnlock()
+			if tag.Quality(time.Now()) != QUALITY_GOOD {
+				return math.NaN()
+			}
+			if cur, ok := tag.Label(); ok && cur == label {
+				return 1
+			}
+			return 0
+		})
+	}
 
-func (c *Controller) incErrCounter() {
-	c.errCounter.Inc()
-}
+	if info != "" {
+		metrics.NewGauge(info, func() float64 {
+			return 1
+		})
+	}
 
-func (c *Controller) Poll() {
-	log.Println("Start polling...")
+	if tag.Action == nil && (Readable(tag) || tag.Expr != nil) {
+		tag.Action = defaultAction
+	}
+	tag.controller = c
 
-	var failAttempts uint = 0
-	c.exit = false
-	needRestart := false
-	for {
-		// Дали команду на выход или количество ошибок превысило ограничение чтобы выйти
-		if c.exit || failAttempts >= c.conf.MaxAttempts {
-			break
-		}
+	tag.Device.tags = append(tag.Device.tags, tag)
+	c.tags = append(c.tags, tag)
 
-		for i, tag := range c.tags {
-			// Принудительный рестарт
-			if needRestart {
-				log.Println("Restarting connect...")
-				err := c.modbusClient.Open()
-				if err != nil {
-					log.Println("Can not open connect")
-					break
-				}
-				needRestart = false
-				failAttempts += 1
-			}
+	return
+}
 
-			time.Sleep(c.conf.ReadPeriod)
+// WriteTag Проверяет значение и записывает его в устройство, недопустимые значения возвращают ValueError
+func (c *Controller) WriteTag(tag *Tag, value float64) (err error) {
+	if err = tag.Validate(value); err != nil {
+		return
+	}
 
-			c.Lock()
-			var err error
-			var val interface{}
+	return tag.Device.conn.write(tag, value)
+}
 
-			if tag.Action != nil {
-				if isUint(tag) {
-					val, err = c.modbusClient.ReadRegister(tag.Address, modbus.HOLDING_REGISTER)
-					c.incCounter()
-				} else if isFloat(tag) {
-					val, err = c.modbusClient.ReadFloat32(tag.Address, modbus.HOLDING_REGISTER)
-					c.incCounter()
-				}
-			}
+// ReqCount Суммарное количество запросов по всем соединениям
+func (c *Controller) ReqCount() (count uint64) {
+	for _, conn := range c.connections {
+		count += conn.reqCounter.Get()
+	}
 
-			// Обработка ошибок
-			if err != nil {
-				c.incErrCounter()
-				log.Printf("Req %d error get tag %s err: %s", c.reqCounter.Get(), tag.Name, err.Error())
-
-				//if cause, ok := err.(interface{ Unwrap() error }); ok {
-				//	if _, ok := cause.(net.Error); ok {
-				//		needRestart = true
-				//	}
-				//}
-				needRestart = true
-				c.modbusClient.Close()
-				c.Unlock()
-				time.Sleep(c.conf.ErrTimeout) // Добавляем задержку, чтобы сломанный пакет протух
-				break
-			}
-			tag.Action(val, c.tags[i])
-			failAttempts = 0 // Сбрасываем счетчик попыток
-			c.Unlock()
-		}
-		time.Sleep(c.conf.PollingTime)
+	return
+}
+
+// ErrCount Суммарное количество ошибок по всем соединениям
+func (c *Controller) ErrCount() (count uint64) {
+	for _, conn := range c.connections {
+		count += conn.errCounter.Get()
 	}
 
-	log.Println("End polling")
-	err := c.modbusClient.Close()
-	if err != nil {
-		log.Println("Controller close error: " + err.Error())
+	return
+}
+
+// Start Запускает опрос всех соединений, каждое соединение опрашивается в своей горутине
+// до остановки контекста или вызова Stop
+func (c *Controller) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	for _, conn := range c.connections {
+		c.wg.Add(1)
+		go func(conn *Connection) {
+			defer c.wg.Done()
+			conn.Poll(ctx)
+		}(conn)
 	}
+}
 
-	os.Exit(2)
+// Stop Останавливает опрос и ждет закрытия всех соединений
+func (c *Controller) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+	c.closeSubscribers()
 }
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"log"
+	"time"
+)
+
+// Device Устройство (slave) на шине, опрашивается через общее соединение контроллера
+type Device struct {
+	Name     string
+	UnitId   uint8
+	Encoding Encoding // Порядок байт и регистров для тегов устройства
+	tags     []*Tag
+	conn     *Connection
+
+	// Связь с устройством, меняется полером под блокировкой контроллера
+	state      ConnState
+	stateSince time.Time
+	lastErr    error
+	fails      uint // Циклов опроса подряд, в которых устройство не ответило
+}
+
+func (d *Device) Connection() *Connection {
+	return d.conn
+}
+
+func (d *Device) Tags() []*Tag {
+	return d.tags
+}
+
+func (d *Device) FindTag(name string) *Tag {
+	for i, tag := range d.tags {
+		if tag.Name == name {
+			return d.tags[i]
+		}
+	}
+
+	return nil
+}
+
+// State Состояние связи с устройством и последняя ошибка. Пока соединение с шиной
+// не работает, состояние устройства не меняется.
+func (d *Device) State() (ConnState, error) {
+	d.conn.controller.RLock()
+	defer d.conn.controller.RUnlock()
+
+	return d.state, d.lastErr
+}
+
+// StateSince Время последней смены состояния связи с устройством
+func (d *Device) StateSince() time.Time {
+	d.conn.controller.RLock()
+	defer d.conn.controller.RUnlock()
+
+	return d.stateSince
+}
+
+// answered Устройство ответило в цикле опроса, хотя бы исключением
+func (d *Device) answered() {
+	d.fails = 0
+	d.setState(CONN_UP, nil)
+}
+
+// failed Устройство не ответило в цикле опроса, после MaxAttempts циклов подряд связи с ним нет
+func (d *Device) failed(err error) {
+	d.fails++
+	state := d.state
+	if d.fails >= d.conn.conf.MaxAttempts {
+		state = CONN_DOWN
+	} else if state == CONN_UP {
+		state = CONN_DEGRADED
+	}
+	d.setState(state, err)
+}
+
+func (d *Device) setState(state ConnState, err error) {
+	d.conn.controller.Lock()
+	prev := d.state
+	d.state = state
+	d.lastErr = err
+	if prev != state {
+		d.stateSince = time.Now()
+	}
+	d.conn.controller.Unlock()
+
+	if prev == state {
+		return
+	}
+
+	switch state {
+	case CONN_UP:
+		log.Printf("[%s] Device %s is up", d.conn.conf.Name, d.Name)
+	case CONN_DEGRADED:
+		log.Printf("[%s] Device %s is degraded: %v", d.conn.conf.Name, d.Name, err)
+	case CONN_DOWN:
+		log.Printf("[%s] Device %s is down after %d attempts: %v", d.conn.conf.Name, d.Name, d.conn.conf.MaxAttempts, err)
+	}
+}
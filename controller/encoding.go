@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ByteOrder Порядок байт внутри регистра
+type ByteOrder uint8
+
+const (
+	BYTE_ORDER_DEFAULT ByteOrder = iota // Не задан, берется с устройства, по умолчанию big endian
+	BIG_ENDIAN
+	LITTLE_ENDIAN
+)
+
+// WordOrder Порядок регистров в многорегистровых значениях
+type WordOrder uint8
+
+const (
+	WORD_ORDER_DEFAULT WordOrder = iota // Не задан, берется с устройства, по умолчанию старший регистр первый
+	HIGH_WORD_FIRST
+	LOW_WORD_FIRST
+)
+
+func (b ByteOrder) String() string {
+	if b == LITTLE_ENDIAN {
+		return "little"
+	}
+	return "big"
+}
+
+func (w WordOrder) String() string {
+	if w == LOW_WORD_FIRST {
+		return "low"
+	}
+	return "high"
+}
+
+// ParseByteOrder Разбор порядка байт из конфигурации: big или little
+func ParseByteOrder(s string) (ByteOrder, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return BYTE_ORDER_DEFAULT, nil
+	case "big", "big_endian", "big-endian":
+		return BIG_ENDIAN, nil
+	case "little", "little_endian", "little-endian":
+		return LITTLE_ENDIAN, nil
+	}
+
+	return BYTE_ORDER_DEFAULT, fmt.Errorf("unsupported byte order %s, must be big or little", s)
+}
+
+// ParseWordOrder Разбор порядка регистров из конфигурации: high или low (какой регистр первый)
+func ParseWordOrder(s string) (WordOrder, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return WORD_ORDER_DEFAULT, nil
+	case "high", "high_word_first", "high-word-first":
+		return HIGH_WORD_FIRST, nil
+	case "low", "low_word_first", "low-word-first":
+		return LOW_WORD_FIRST, nil
+	}
+
+	return WORD_ORDER_DEFAULT, fmt.Errorf("unsupported word order %s, must be high or low", s)
+}
+
+// Encoding Порядок байт и регистров, незаданные поля наследуются
+type Encoding struct {
+	ByteOrder ByteOrder
+	WordOrder WordOrder
+}
+
+// inherit Заполняет незаданные поля из parent
+func (e Encoding) inherit(parent Encoding) Encoding {
+	if e.ByteOrder == BYTE_ORDER_DEFAULT {
+		e.ByteOrder = parent.ByteOrder
+	}
+	if e.WordOrder == WORD_ORDER_DEFAULT {
+		e.WordOrder = parent.WordOrder
+	}
+	return e
+}
+
+// reorder Переводит регистры между порядком устройства и порядком декодера (big endian,
+// старший регистр первый). Преобразование симметричное, используется и для чтения и для записи.
+// Для строк порядок регистров не меняется.
+func (e Encoding) reorder(regs []uint16, d DataType) []uint16 {
+	res := make([]uint16, len(regs))
+	copy(res, regs)
+
+	if e.ByteOrder == LITTLE_ENDIAN {
+		for i, r := range res {
+			res[i] = r<<8 | r>>8
+		}
+	}
+
+	if e.WordOrder == LOW_WORD_FIRST && d != TYPE_STRING {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"time"
+)
+
+// Размер очереди обновлений одного подписчика
+const updateQueueSize = 256
+
+// Update Новое значение тега, отправляется подписчикам после каждого успешного чтения
+type Update struct {
+	Tag     *Tag
+	Value   interface{}
+	Time    time.Time
+	Changed bool // Значение отличается от предыдущего
+}
+
+// Subscribe Подписка на обновления значений тегов, вызывается до Start. Если подписчик
+// не успевает разбирать очередь, обновления для него теряются, опрос при этом не тормозится.
+// Канал закрывается после Stop.
+func (c *Controller) Subscribe() <-chan Update {
+	ch := make(chan Update, updateQueueSize)
+
+	c.Lock()
+	c.subscribers = append(c.subscribers, ch)
+	c.Unlock()
+
+	return ch
+}
+
+// notify Рассылает обновление тега подписчикам, вызывается под блокировкой контроллера
+func (c *Controller) notify(tag *Tag, prev interface{}, now time.Time) {
+	if tag.LastValue == nil {
+		return
+	}
+
+	u := Update{Tag: tag, Value: tag.LastValue, Time: now, Changed: prev != tag.LastValue}
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- u:
+		default:
+		}
+	}
+}
+
+// closeSubscribers Закрывает каналы подписчиков после остановки опроса
+func (c *Controller) closeSubscribers() {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, ch := range c.subscribers {
+		close(ch)
+	}
+	c.subscribers = nil
+}
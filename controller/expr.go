@@ -0,0 +1,480 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr Разобранное выражение над значениями тегов, например "temp_otopl - temp_floor" или "status & 0x04".
+// Поддерживаются арифметика, битовые операции, сравнения, логические операции, скобки
+// и функции abs, min, max, round, bit(x, n). Числа десятичные, в том числе с экспонентой (1e-3),
+// шестнадцатеричные (0x1f) и двоичные (0b101). Имена с недопустимыми символами пишутся в фигурных скобках: {boiler/status}.
+// Приоритеты операций как в Go.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(lookup func(string) (float64, bool)) (float64, error)
+	vars(res []string) []string
+}
+
+// ParseExpr Разбор выражения
+func ParseExpr(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("expr %q: unexpected %q at %d", src, p.tok.text, p.tok.pos)
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars Имена тегов, от которых зависит выражение
+func (e *Expr) Vars() []string {
+	return e.root.vars(nil)
+}
+
+// Eval Вычисление выражения, lookup возвращает значение тега по имени
+func (e *Expr) Eval(lookup func(string) (float64, bool)) (float64, error) {
+	return e.root.eval(lookup)
+}
+
+// Узлы выражения
+
+type numNode float64
+
+func (n numNode) eval(func(string) (float64, bool)) (float64, error) {
+	return float64(n), nil
+}
+
+func (n numNode) vars(res []string) []string {
+	return res
+}
+
+type varNode string
+
+func (n varNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	v, ok := lookup(string(n))
+	if !ok {
+		return 0, fmt.Errorf("no value for %s", string(n))
+	}
+	return v, nil
+}
+
+func (n varNode) vars(res []string) []string {
+	return append(res, string(n))
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	x, err := n.x.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "-":
+		return -x, nil
+	case "+":
+		return x, nil
+	case "!":
+		return boolToFloat(x == 0), nil
+	case "~":
+		return float64(^int64(x)), nil
+	}
+	return 0, fmt.Errorf("unknown operation %s", n.op)
+}
+
+func (n *unaryNode) vars(res []string) []string {
+	return n.x.vars(res)
+}
+
+type binaryNode struct {
+	op   string
+	x, y exprNode
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (n *binaryNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	x, err := n.x.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	// Логические операции вычисляются по короткой схеме
+	if n.op == "&&" && x == 0 {
+		return 0, nil
+	}
+	if n.op == "||" && x != 0 {
+		return 1, nil
+	}
+
+	y, err := n.y.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	case "%":
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(x, y), nil
+	case "&":
+		return float64(int64(x) & int64(y)), nil
+	case "|":
+		return float64(int64(x) | int64(y)), nil
+	case "^":
+		return float64(int64(x) ^ int64(y)), nil
+	case "<<":
+		return float64(int64(x) << uint64(y)), nil
+	case ">>":
+		return float64(int64(x) >> uint64(y)), nil
+	case "==":
+		return boolToFloat(x == y), nil
+	case "!=":
+		return boolToFloat(x != y), nil
+	case "<":
+		return boolToFloat(x < y), nil
+	case "<=":
+		return boolToFloat(x <= y), nil
+	case ">":
+		return boolToFloat(x > y), nil
+	case ">=":
+		return boolToFloat(x >= y), nil
+	case "&&", "||":
+		return boolToFloat(y != 0), nil
+	}
+	return 0, fmt.Errorf("unknown operation %s", n.op)
+}
+
+func (n *binaryNode) vars(res []string) []string {
+	return n.y.vars(n.x.vars(res))
+}
+
+type callNode struct {
+	fn   string
+	args []exprNode
+}
+
+var exprFuncs = map[string]func(args []float64) (float64, error){
+	"abs": func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("abs needs 1 argument")
+		}
+		return math.Abs(args[0]), nil
+	},
+	"round": func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("round needs 1 argument")
+		}
+		return math.Round(args[0]), nil
+	},
+	"bit": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("bit needs 2 arguments")
+		}
+		return float64(int64(args[0]) >> uint(args[1]) & 1), nil
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("min needs arguments")
+		}
+		res := args[0]
+		for _, v := range args[1:] {
+			res = math.Min(res, v)
+		}
+		return res, nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("max needs arguments")
+		}
+		res := args[0]
+		for _, v := range args[1:] {
+			res = math.Max(res, v)
+		}
+		return res, nil
+	},
+}
+
+func (n *callNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(lookup)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return exprFuncs[n.fn](args)
+}
+
+func (n *callNode) vars(res []string) []string {
+	for _, arg := range n.args {
+		res = arg.vars(res)
+	}
+	return res
+}
+
+// Разбор
+
+const (
+	tokEOF = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	num  float64
+	pos  int
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok exprToken
+}
+
+// Приоритеты бинарных операций, как в Go
+var exprPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4, "|": 4, "^": 4,
+	"*": 5, "/": 5, "%": 5, "<<": 5, ">>": 5, "&": 5,
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || r == '.')
+}
+
+func (p *exprParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = exprToken{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	rest := p.src[p.pos:]
+	c := rune(rest[0])
+	switch {
+	case unicode.IsDigit(c) || (c == '.' && len(rest) > 1 && unicode.IsDigit(rune(rest[1]))):
+		prefixed := len(rest) > 1 && rest[0] == '0' && strings.ContainsRune("xXbB", rune(rest[1]))
+		end := 0
+		for end < len(rest) {
+			// Знак после экспоненты десятичного числа относится к числу: 1e-3
+			if !prefixed && (rest[end] == 'e' || rest[end] == 'E') && end+1 < len(rest) && (rest[end+1] == '-' || rest[end+1] == '+') {
+				end += 2
+				continue
+			}
+			if !unicode.IsDigit(rune(rest[end])) && !unicode.IsLetter(rune(rest[end])) && rest[end] != '.' {
+				break
+			}
+			end++
+		}
+		text := rest[:end]
+		var num float64
+		if prefixed {
+			v, err := strconv.ParseInt(text, 0, 64)
+			if err != nil {
+				return fmt.Errorf("expr %q: bad number %q", p.src, text)
+			}
+			num = float64(v)
+		} else {
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return fmt.Errorf("expr %q: bad number %q", p.src, text)
+			}
+			num = v
+		}
+		p.pos += end
+		p.tok = exprToken{kind: tokNum, text: text, num: num, pos: start}
+	case c == '{':
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return fmt.Errorf("expr %q: unclosed { at %d", p.src, start)
+		}
+		p.pos += end + 1
+		p.tok = exprToken{kind: tokIdent, text: strings.TrimSpace(rest[1:end]), pos: start}
+	case c >= 0x80 || isIdentRune(c, true):
+		end := 0
+		for _, r := range rest {
+			if !isIdentRune(r, end == 0) {
+				break
+			}
+			end += len(string(r))
+		}
+		if end == 0 {
+			return fmt.Errorf("expr %q: unexpected symbol %q at %d", p.src, rest, start)
+		}
+		p.pos += end
+		p.tok = exprToken{kind: tokIdent, text: rest[:end], pos: start}
+	default:
+		for _, op := range []string{"<<", ">>", "<=", ">=", "==", "!=", "&&", "||"} {
+			if strings.HasPrefix(rest, op) {
+				p.pos += 2
+				p.tok = exprToken{kind: tokOp, text: op, pos: start}
+				return nil
+			}
+		}
+		if strings.ContainsRune("+-*/%&|^<>!~(),", c) {
+			p.pos++
+			p.tok = exprToken{kind: tokOp, text: string(c), pos: start}
+			return nil
+		}
+		return fmt.Errorf("expr %q: unexpected symbol %q at %d", p.src, c, start)
+	}
+
+	return nil
+}
+
+// parseBinary Разбор бинарных операций с приоритетом не ниже minPrec
+func (p *exprParser) parseBinary(minPrec int) (exprNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		prec, ok := exprPrecedence[p.tok.text]
+		if p.tok.kind != tokOp || !ok || prec <= minPrec {
+			return x, nil
+		}
+
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		y, err := p.parseBinary(prec)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNum:
+		return numNode(tok.num), p.next()
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokOp && p.tok.text == "(" {
+			return p.parseCall(tok)
+		}
+		return varNode(tok.text), nil
+	case tokOp:
+		switch tok.text {
+		case "-", "+", "!", "~":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{op: tok.text, x: x}, nil
+		case "(":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			x, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokOp || p.tok.text != ")" {
+				return nil, fmt.Errorf("expr %q: expected ) at %d", p.src, p.tok.pos)
+			}
+			return x, p.next()
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("expr %q: unexpected end", p.src)
+	}
+
+	return nil, fmt.Errorf("expr %q: unexpected %q at %d", p.src, tok.text, tok.pos)
+}
+
+func (p *exprParser) parseCall(fn exprToken) (exprNode, error) {
+	if _, ok := exprFuncs[fn.text]; !ok {
+		return nil, fmt.Errorf("expr %q: unknown function %s", p.src, fn.text)
+	}
+
+	call := &callNode{fn: fn.text}
+	if err := p.next(); err != nil { // (
+		return nil, err
+	}
+	if p.tok.kind == tokOp && p.tok.text == ")" {
+		return call, p.next()
+	}
+
+	for {
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		if p.tok.kind == tokOp && p.tok.text == "," {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.tok.kind == tokOp && p.tok.text == ")" {
+			return call, p.next()
+		}
+		return nil, fmt.Errorf("expr %q: expected , or ) at %d", p.src, p.tok.pos)
+	}
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var exprValues = map[string]float64{
+	"a":             10,
+	"b":             3,
+	"status":        0b101100,
+	"boiler/status": 7,
+	"temp.in":       20,
+	"темп":          5,
+}
+
+func exprLookup(name string) (float64, bool) {
+	v, ok := exprValues[name]
+	return v, ok
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		// Приоритеты и ассоциативность
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"100 / 10 / 5", 2},
+		{"2 * 3 % 4", 2},
+		{"-2 * 3", -6},
+		{"- -2", 2},
+		{"1 + 2 == 3", 1},
+		{"1 < 2 && 3 < 2 || 1", 1},
+		{"1 || 0 && 0", 1},
+		{"status & 0x04 != 0", 1},
+		{"1 << 2 + 1", 5},
+		{"6 & 3 | 8", 10},
+		{"6 ^ 3 * 2", 0},
+		{"!a", 0},
+		{"!0 + 1", 2},
+		{"~0", -1},
+		// Числа
+		{"0x1F", 31},
+		{"0XfF", 255},
+		{"0b101", 5},
+		{"0xe-1", 13},
+		{"1.5", 1.5},
+		{".5", 0.5},
+		{"1e3", 1000},
+		{"1e-3", 0.001},
+		{"2.5E+2", 250},
+		{"a*1e-1", 1},
+		// Имена
+		{"{boiler/status} + 1", 8},
+		{"{ boiler/status }", 7},
+		{"temp.in * 2", 40},
+		{"темп + 1", 6},
+		// Функции
+		{"abs(-3) + max(1, a, b) + min(b, 2)", 15},
+		{"round(2.5)", 3},
+		{"bit(status, 5)", 1},
+		{"bit(status, 0)", 0},
+		// Короткая схема не вычисляет правую часть
+		{"0 && 1/0", 0},
+		{"1 || 1/0", 1},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) error: %s", tt.src, err)
+			continue
+		}
+		got, err := e.Eval(exprLookup)
+		if err != nil {
+			t.Errorf("Eval(%q) error: %s", tt.src, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("Eval(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		// Ошибки разбора
+		{"foo(1)", "unknown function foo"},
+		{"1e", `bad number "1e"`},
+		{"0x", `bad number "0x"`},
+		{"12ab", `bad number "12ab"`},
+		{"(1 + 2", "expected )"},
+		{"1 +", "unexpected end"},
+		{"", "unexpected end"},
+		{"1 2", `unexpected "2"`},
+		{"{boiler", "unclosed {"},
+		{"a $ b", "unexpected symbol"},
+		{"max(1 2)", "expected , or )"},
+		// Ошибки вычисления
+		{"a / 0", "division by zero"},
+		{"a % (b - 3)", "division by zero"},
+		{"abs(1, 2)", "abs needs 1 argument"},
+		{"bit(1)", "bit needs 2 arguments"},
+		{"max()", "max needs arguments"},
+		{"unknown + 1", "no value for unknown"},
+		{"{other/tag}", "no value for other/tag"},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.src)
+		if err == nil {
+			_, err = e.Eval(exprLookup)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestExprVars(t *testing.T) {
+	e, err := ParseExpr("a + {boiler/status} * max(b, a) - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "boiler/status", "b", "a"}
+	if got := e.Vars(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Vars() = %q, want %q", got, want)
+	}
+}
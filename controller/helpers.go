@@ -1,24 +1,15 @@
 package controller
 
 import (
+	"fmt"
 	"log"
-	"os"
-	"strconv"
 	"strings"
+	"time"
 )
 
-func defaultFloat32Action(val interface{}, t *Tag) {
+func defaultAction(val interface{}, t *Tag) {
 	if t.LastValue != val {
-		v := val.(float32)
-		log.Printf("req %d tag %s = %f", t.controller.reqCounter.Get(), t.Name, v)
-		t.LastValue = v
-	}
-}
-
-func defaultUint16Action(val interface{}, t *Tag) {
-	if t.LastValue != val {
-		v := val.(uint16)
-		log.Printf("req %d tag %s = %d", t.controller.reqCounter.Get(), t.Name, v)
+		log.Printf("req %d tag %s = %v", t.Device.conn.reqCounter.Get(), t.Name, val)
 		t.LastValue = val
 	}
 }
@@ -28,24 +19,23 @@ func isFlag(t *Tag, f OperationType) bool {
 	return (t.Method & uf) == uf
 }
 
-func isUint(t *Tag) bool {
-	return isFlag(t, READ_UINT)
+func Readable(t *Tag) bool {
+	return t.Method&(READ|READ_UINT|READ_FLOAT|READ_BOOL) != 0
 }
 
-func isWriteUint(t *Tag) bool {
-	return isFlag(t, WRITE_UINT)
-}
-
-func isFloat(t *Tag) bool {
-	return isFlag(t, READ_FLOAT)
-}
-
-func isWriteFloat(t *Tag) bool {
-	return isFlag(t, WRITE_FLOAT)
+func Writable(t *Tag) bool {
+	return t.Method&(WRITE|WRITE_UINT|WRITE_FLOAT|WRITE_BOOL) != 0
 }
 
-func Writable(t *Tag) bool {
-	return isWriteUint(t) || isWriteFloat(t)
+// operationType Тип значения для старых операций read_uint/read_float/read_bool
+func operationType(t *Tag) DataType {
+	if isFlag(t, READ_FLOAT) || isFlag(t, WRITE_FLOAT) {
+		return TYPE_FLOAT32
+	}
+	if isFlag(t, READ_BOOL) || isFlag(t, WRITE_BOOL) || t.RegisterType.isBit() {
+		return TYPE_BOOL
+	}
+	return TYPE_UINT16
 }
 
 func ValToStr(t *Tag) string {
@@ -53,37 +43,72 @@ func ValToStr(t *Tag) string {
 		return "0"
 	}
 
-	if isUint(t) {
-		return strconv.Itoa(int(t.LastValue.(uint16)))
-	} else if isFloat(t) {
-		return strconv.FormatFloat(float64(t.LastValue.(float32)), 'f', 2, 32)
-	} else {
-		return "unknown"
+	if label, ok := t.Label(); ok {
+		return label
 	}
+
+	// Биты статусных регистров показываем как вкл/выкл
+	if t.Parent != nil {
+		if t.LastValue.(bool) {
+			return "вкл"
+		}
+		return "выкл"
+	}
+
+	return formatValue(t.LastValue)
 }
 
-func ParseOperation(op string) (t uint8) {
-	var res uint8 = 0
+// ValToFloat Числовое значение тега, строки не преобразуются
+func ValToFloat(val interface{}) (float64, bool) {
+	return toFloat(val)
+}
 
-	if strings.Contains(op, "read_uint") {
-		res |= READ_UINT
-	}
-	if strings.Contains(op, "read_float") {
-		res |= READ_FLOAT
+// ValToStrWithUnit Значение тега вместе с единицами измерения и отметкой о качестве
+func ValToStrWithUnit(t *Tag) string {
+	var res string
+	switch t.Quality(time.Now()) {
+	case QUALITY_NEVER_READ:
+		return "нет данных"
+	case QUALITY_STALE:
+		res = " (устарело)"
 	}
-	if strings.Contains(op, "write_uint") {
-		res |= WRITE_UINT
+
+	if _, ok := t.Label(); ok || t.Unit == "" {
+		return ValToStr(t) + res
 	}
-	if strings.Contains(op, "write_float") {
-		res |= WRITE_FLOAT
+
+	return ValToStr(t) + " " + t.Unit + res
+}
+
+// ParseOperation Разбор операций тега, операции разделяются "|": read, write,
+// а также старые read_uint, read_float, read_bool, write_uint, write_float, write_bool
+func ParseOperation(op string) (t uint8, err error) {
+	var res uint8 = 0
+
+	for _, token := range strings.FieldsFunc(op, func(r rune) bool { return r == '|' || r == ',' || r == ' ' }) {
+		switch token {
+		case "read":
+			res |= READ
+		case "write":
+			res |= WRITE
+		case "read_uint":
+			res |= READ_UINT
+		case "read_float":
+			res |= READ_FLOAT
+		case "read_bool":
+			res |= READ_BOOL
+		case "write_uint":
+			res |= WRITE_UINT
+		case "write_float":
+			res |= WRITE_FLOAT
+		case "write_bool":
+			res |= WRITE_BOOL
+		}
 	}
 
 	if res > 0 {
-		return res
+		return res, nil
 	}
 
-	log.Println("Unsupported operation " + op + " must be read_uint, read_float")
-	os.Exit(1)
-
-	return 0
+	return 0, fmt.Errorf("unsupported operation %s must be read, write, read_uint, read_float, read_bool", op)
 }
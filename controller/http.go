@@ -7,8 +7,9 @@ import (
 )
 
 type WriteTag struct {
-	Name  string  `json:"name"`
-	Value float64 `json:"value"`
+	Device string  `json:"device"`
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
 }
 
 func TagsHahdler(c *Controller) http.HandlerFunc {
@@ -16,7 +17,7 @@ func TagsHahdler(c *Controller) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 		data, err := c.Json()
 		if err != nil {
-			log.Println("Cannot make json err: " + err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -46,7 +47,11 @@ func (c *Controller) WriteTagsHandler() http.HandlerFunc {
 
 			// Пробуем найти тег
 			log.Printf("Request to write %s tag with value %f", writeTag.Name, writeTag.Value)
-			tag := c.FindTag(writeTag.Name)
+			tagName := writeTag.Name
+			if writeTag.Device != "" {
+				tagName = writeTag.Device + "/" + writeTag.Name
+			}
+			tag := c.FindTag(tagName)
 			if tag == nil {
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte("Bad Request: tag not found"))
@@ -66,7 +71,11 @@ func (c *Controller) WriteTagsHandler() http.HandlerFunc {
 			if err != nil {
 				log.Printf("Write tag %s error: %s", tag.Name, err.Error())
 				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("Bad Request: write modbus error"))
+				if IsValueError(err) {
+					w.Write([]byte("Bad Request: " + err.Error()))
+				} else {
+					w.Write([]byte("Bad Request: write modbus error: " + err.Error()))
+				}
 				return
 			}
 
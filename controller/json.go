@@ -3,18 +3,70 @@ package controller
 import (
 	"encoding/json"
 	"log"
+	"math"
+	"time"
 )
 
 type JsonTag struct {
-	Name    string      `json:"name"`
-	Address uint16      `json:"address"`
-	Value   interface{} `json:"value"`
+	Name      string      `json:"name"`
+	Address   uint16      `json:"address"`
+	Register  string      `json:"register,omitempty"`
+	Expr      string      `json:"expr,omitempty"`
+	Parent    string      `json:"parent,omitempty"`
+	Bit       *uint8      `json:"bit,omitempty"`
+	Type      string      `json:"type"`
+	Value     interface{} `json:"value"`
+	Label     string      `json:"label,omitempty"`
+	Unit      string      `json:"unit,omitempty"`
+	Quality   string      `json:"quality"`
+	Interval  string      `json:"interval,omitempty"`
+	LastRead  *time.Time  `json:"last_read,omitempty"`
+	NextDue   *time.Time  `json:"next_due,omitempty"`
+	LastError string      `json:"last_error,omitempty"`
+	ErrorTime *time.Time  `json:"last_error_time,omitempty"`
 }
 
-type JsonResponse struct {
+func jsonTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// jsonValue NaN и бесконечности в JSON не записываются, отдаем их как null.
+// Например float32 0xFFFFFFFF, которым датчики сообщают о неисправности, это NaN.
+func jsonValue(val interface{}) interface{} {
+	if f, ok := toFloat(val); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return nil
+	}
+	return val
+}
+
+type JsonDevice struct {
+	Name       string    `json:"name"`
+	Connection string    `json:"connection"`
+	UnitId     uint8     `json:"unit_id"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Error      string    `json:"error,omitempty"`
+	Tags       []JsonTag `json:"tags"`
+}
+
+type JsonConnection struct {
+	Name     string    `json:"name"`
+	Url      string    `json:"url"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Error    string    `json:"error,omitempty"`
 	ReqCount uint64    `json:"req_count"`
 	ErrCount uint64    `json:"err_count"`
-	Tags     []JsonTag `json:"tags"`
+}
+
+type JsonResponse struct {
+	ReqCount    uint64           `json:"req_count"`
+	ErrCount    uint64           `json:"err_count"`
+	Connections []JsonConnection `json:"connections"`
+	Devices     []JsonDevice     `json:"devices"`
 }
 
 func (c *Controller) Json() (data []byte, err error) {
@@ -22,22 +74,74 @@ func (c *Controller) Json() (data []byte, err error) {
 	defer c.RUnlock()
 
 	var response = JsonResponse{
-		ReqCount: c.reqCounter.Get(),
-		ErrCount: c.errCounter.Get(),
+		ReqCount: c.ReqCount(),
+		ErrCount: c.ErrCount(),
 	}
 
-	for _, tag := range c.tags {
-		t := JsonTag{
-			Name:    tag.Name,
-			Address: tag.Address,
-			Value:   tag.LastValue,
+	for _, conn := range c.connections {
+		jc := JsonConnection{
+			Name:     conn.conf.Name,
+			Url:      conn.conf.Url,
+			State:    conn.state.String(),
+			Since:    conn.stateSince,
+			ReqCount: conn.reqCounter.Get(),
+			ErrCount: conn.errCounter.Get(),
+		}
+		if conn.lastErr != nil {
+			jc.Error = conn.lastErr.Error()
+		}
+		response.Connections = append(response.Connections, jc)
+	}
+
+	now := time.Now()
+	for _, dev := range c.Devices() {
+		d := JsonDevice{
+			Name:       dev.Name,
+			Connection: dev.conn.conf.Name,
+			UnitId:     dev.UnitId,
+			State:      dev.state.String(),
+			Since:      dev.stateSince,
+		}
+		if dev.lastErr != nil {
+			d.Error = dev.lastErr.Error()
+		}
+		for _, tag := range dev.tags {
+			t := JsonTag{
+				Name:      tag.Name,
+				Address:   tag.Address,
+				Register:  tag.RegisterType.String(),
+				Type:      tag.Type.String(),
+				Value:     jsonValue(tag.LastValue),
+				Unit:      tag.Unit,
+				Quality:   tag.Quality(now).String(),
+				LastRead:  jsonTime(tag.LastRead),
+				NextDue:   jsonTime(tag.NextDue),
+				LastError: tag.LastError,
+				ErrorTime: jsonTime(tag.LastErrorTime),
+			}
+			if label, ok := tag.Label(); ok {
+				t.Label = label
+			}
+			if tag.Expr != nil {
+				t.Register = ""
+				t.Expr = tag.Expr.String()
+			}
+			if tag.Parent != nil {
+				bit := tag.Bit
+				t.Parent = tag.Parent.Name
+				t.Bit = &bit
+			}
+			if tag.Interval > 0 {
+				t.Interval = tag.Interval.String()
+			}
+			d.Tags = append(d.Tags, t)
 		}
-		response.Tags = append(response.Tags, t)
+		response.Devices = append(response.Devices, d)
 	}
 
 	data, err = json.Marshal(response)
 	if err != nil {
-		log.Println("Can not make json out put")
+		log.Println("Can not make json out put: " + err.Error())
 	}
 	return
 }
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/VictoriaMetrics/metrics"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTagsHandlerNonFinite(t *testing.T) {
+	c := testController(t, [][2]string{{"boiler/nan", ""}, {"boiler/inf", ""}, {"boiler/temp", ""}}, 0)
+	conn := c.connections[0]
+	conn.reqCounter, conn.errCounter = &metrics.Counter{}, &metrics.Counter{}
+
+	dev := c.FindDevice("boiler")
+	dev.FindTag("nan").LastValue = float32(math.NaN())
+	dev.FindTag("inf").LastValue = math.Inf(-1)
+	dev.FindTag("temp").LastValue = float32(21.5)
+
+	rec := httptest.NewRecorder()
+	TagsHahdler(c)(rec, httptest.NewRequest(http.MethodGet, "/tags", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var res JsonResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("body %q: %s", rec.Body, err)
+	}
+	want := map[string]interface{}{"nan": nil, "inf": nil, "temp": 21.5}
+	for _, tag := range res.Devices[0].Tags {
+		if tag.Value != want[tag.Name] {
+			t.Errorf("%s = %v, want %v", tag.Name, tag.Value, want[tag.Name])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Метки, которые выставляются автоматически и не могут задаваться в конфиге
+var reservedLabels = map[string]bool{
+	"tag":    true,
+	"device": true,
+	"group":  true,
+	"unit":   true,
+	"state":  true,
+	"desc":   true,
+}
+
+// validLabelName Проверка имени метки prometheus: [a-zA-Z_][a-zA-Z0-9_]*
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// validMetricName Проверка имени метрики prometheus: [a-zA-Z_:][a-zA-Z0-9_:]*
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// checkLabels Проверка меток тега из конфига
+func (t *Tag) checkLabels() error {
+	for name := range t.Labels {
+		if !validLabelName(name) {
+			return fmt.Errorf("tag %s: bad label name %q", t.Name, name)
+		}
+		if reservedLabels[name] {
+			return fmt.Errorf("tag %s: label %s is set automatically", t.Name, name)
+		}
+	}
+	return nil
+}
+
+// inheritLabels Битовый тег получает метки родителя, свои метки имеют приоритет
+func (t *Tag) inheritLabels(parent *Tag) {
+	if len(parent.Labels) == 0 {
+		return
+	}
+
+	labels := make(map[string]string, len(parent.Labels)+len(t.Labels))
+	for k, v := range parent.Labels {
+		labels[k] = v
+	}
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	t.Labels = labels
+}
+
+// metricName Имя метрики тега с метками. Если задано семейство MetricFamily, то все теги
+// пишутся в одну метрику с меткой tag, иначе имя метрики - имя тега с префиксом MetricPrefix.
+// extra - дополнительные пары меток вида key="value"
+func (c *Controller) metricName(t *Tag, suffix string, extra ...string) string {
+	var name string
+	var labels []string
+	if c.conf.MetricFamily != "" {
+		name = c.conf.MetricFamily + suffix
+		labels = append(labels, fmt.Sprintf("tag=%q", t.Name))
+	} else {
+		name = sanitizeMetricName(t.Name)
+		// Имя метрики не может начинаться с цифры
+		if c.conf.MetricPrefix == "" && name != "" && name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+		name = c.conf.MetricPrefix + name + suffix
+	}
+
+	labels = append(labels, fmt.Sprintf("device=%q", t.Device.Name))
+	if t.Group != "" {
+		labels = append(labels, fmt.Sprintf("group=%q", t.Group))
+	}
+	if t.Unit != "" {
+		labels = append(labels, fmt.Sprintf("unit=%q", t.Unit))
+	}
+
+	keys := make([]string, 0, len(t.Labels))
+	for k := range t.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, t.Labels[k]))
+	}
+	labels = append(labels, extra...)
+
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// reserveMetrics Запоминает метрики тега. Если метрика уже занята другим тегом (например
+// "status.bit3" и "status_bit3" дают одно имя), возвращает ошибку и ничего не запоминает.
+func (c *Controller) reserveMetrics(t *Tag, names []string) error {
+	for _, name := range names {
+		if other := c.metricTags[name]; other != nil {
+			return fmt.Errorf("tag %s: metric %s is already used by tag %s", t.FullName(), name, other.FullName())
+		}
+	}
+	for _, name := range names {
+		c.metricTags[name] = t
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewMetricNames(t *testing.T) {
+	tests := []struct {
+		conf Configuration
+		err  string
+	}{
+		{Configuration{MetricPrefix: "boiler_"}, ""},
+		{Configuration{MetricPrefix: "ns:boiler_"}, ""},
+		{Configuration{MetricFamily: "modbus_value"}, ""},
+		{Configuration{MetricPrefix: "modbus-"}, "bad metric prefix"},
+		{Configuration{MetricPrefix: "1st_"}, "bad metric prefix"},
+		{Configuration{MetricPrefix: "котел_"}, "bad metric prefix"},
+		{Configuration{MetricFamily: "modbus value"}, "bad metric family"},
+		{Configuration{MetricFamily: "modbus{x}"}, "bad metric family"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(&tt.conf)
+		if tt.err == "" && err != nil {
+			t.Errorf("New(%+v) error: %s", tt.conf, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("New(%+v) error %v, want %q", tt.conf, err, tt.err)
+		}
+	}
+}
+
+func TestAddTagMetricCollision(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		first  string
+		second string
+		err    string
+	}{
+		{"bit name", "t17a_", "status.bit3", "status_bit3", "metric t17a_status_bit3{device=\"boiler\"} is already used by tag boiler/status.bit3"},
+		{"cyrillic names", "t17b_", "темп", "тест", "is already used by tag boiler/темп"},
+		{"leading digit", "", "1t17c", "_1t17c", "is already used by tag boiler/1t17c"},
+		{"different names", "t17d_", "temp", "temp2", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(&Configuration{MetricPrefix: tt.prefix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := &Connection{controller: c}
+			dev := &Device{Name: "boiler", conn: conn}
+			conn.devices = []*Device{dev}
+			c.connections = []*Connection{conn}
+
+			if err := c.AddTag(&Tag{Name: tt.first, Device: dev, Method: READ, Type: TYPE_UINT16}); err != nil {
+				t.Fatalf("AddTag(%s) error: %s", tt.first, err)
+			}
+			err = c.AddTag(&Tag{Name: tt.second, Device: dev, Method: READ, Type: TYPE_UINT16})
+			if tt.err == "" && err != nil {
+				t.Errorf("AddTag(%s) error: %s", tt.second, err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("AddTag(%s) error %v, want %q", tt.second, err, tt.err)
+			}
+			if tt.err != "" && len(dev.Tags()) != 1 {
+				t.Errorf("rejected tag %s was added to device", tt.second)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"time"
+)
+
+// Quality Качество значения тега
+type Quality uint8
+
+const (
+	QUALITY_NEVER_READ Quality = iota // Значение еще ни разу не прочитано
+	QUALITY_GOOD
+	QUALITY_STALE // Значение давно не обновлялось
+)
+
+func (q Quality) String() string {
+	switch q {
+	case QUALITY_GOOD:
+		return "good"
+	case QUALITY_STALE:
+		return "stale"
+	}
+	return "never_read"
+}
+
+// staleAfter Через сколько после последнего чтения значение считается устаревшим.
+// Для тегов с редким опросом порог не меньше двух периодов опроса.
+func (t *Tag) staleAfter() time.Duration {
+	if t.Parent != nil {
+		return t.Parent.staleAfter()
+	}
+
+	threshold := t.StaleAfter
+	if threshold == 0 && t.Device != nil {
+		threshold = t.Device.conn.conf.StaleAfter
+	}
+	if t.Interval > 0 && threshold < 2*t.Interval {
+		threshold = 2 * t.Interval
+	}
+	return threshold
+}
+
+// Quality Качество значения на момент now
+func (t *Tag) Quality(now time.Time) Quality {
+	if t.LastValue == nil || t.LastRead.IsZero() {
+		return QUALITY_NEVER_READ
+	}
+	if now.Sub(t.LastRead) > t.staleAfter() {
+		return QUALITY_STALE
+	}
+	return QUALITY_GOOD
+}
+
+// markError Запоминает ошибку чтения тега и его битов
+func (t *Tag) markError(err error, now time.Time) {
+	t.LastError = err.Error()
+	t.LastErrorTime = now
+	for _, bit := range t.bits {
+		bit.markError(err, now)
+	}
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const writeQueueSize = 16
+
+// writeRequest Запрос записи в очереди соединения
+type writeRequest struct {
+	ctx    context.Context
+	tag    *Tag
+	value  float64
+	result chan error
+}
+
+// write Ставит запись в очередь полера и ждет результат не дольше WriteTimeout
+func (c *Connection) write(tag *Tag, value float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.conf.WriteTimeout)
+	defer cancel()
+
+	req := &writeRequest{
+		ctx:    ctx,
+		tag:    tag,
+		value:  value,
+		result: make(chan error, 1),
+	}
+
+	select {
+	case c.writes <- req:
+	case <-ctx.Done():
+		return fmt.Errorf("write %s: queue is full", tag.FullName())
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("write %s: timed out", tag.FullName())
+	}
+}
+
+// wait Пауза полера, во время которой выполняются записи из очереди.
+// Возвращает false если контекст остановлен.
+func (c *Connection) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case req := <-c.writes:
+			c.handleWrite(req)
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// drainWrites Выполняет все записи, которые уже стоят в очереди
+func (c *Connection) drainWrites() {
+	for {
+		select {
+		case req := <-c.writes:
+			c.handleWrite(req)
+		default:
+			return
+		}
+	}
+}
+
+// handleWrite Выполняет запись и сразу перечитывает тег, чтобы /tags показывал новое значение
+func (c *Connection) handleWrite(req *writeRequest) {
+	// Вызывающий уже не ждет ответа
+	if req.ctx.Err() != nil {
+		return
+	}
+
+	if !c.open {
+		req.result <- fmt.Errorf("connection %s is not open", c.conf.Name)
+		return
+	}
+
+	tag := req.tag
+	err := c.execWrite(tag, req.value)
+	c.incCounter()
+	if err != nil {
+		c.incErrCounter()
+		req.result <- err
+		return
+	}
+
+	// Ответ отдаем после перечитывания, чтобы вызывающий сразу видел новое значение
+	var verifyErr error
+	defer func() { req.result <- verifyErr }()
+
+	// Перечитываем тег, для битового тега читается родительский регистр
+	if tag.Parent != nil {
+		tag = tag.Parent
+	}
+	if tag.Action == nil {
+		return
+	}
+
+	time.Sleep(c.conf.ReadPeriod)
+	blk := &block{
+		regType: tag.RegisterType,
+		address: tag.Address,
+		count:   regCount(tag),
+		tags:    []*Tag{tag},
+	}
+	if err := c.readBlock(tag.Device, blk); err != nil {
+		c.incErrCounter()
+		log.Printf("[%s] Read back %s error: %s", c.conf.Name, tag.FullName(), err.Error())
+		if req.tag.Verify {
+			verifyErr = fmt.Errorf("verify %s: %w", req.tag.FullName(), err)
+		}
+		return
+	}
+	c.store(blk)
+
+	if req.tag.Verify {
+		c.controller.RLock()
+		verifyErr = req.tag.verifyValue(req.value)
+		c.controller.RUnlock()
+	}
+}
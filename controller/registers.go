@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RegisterType Таблица modbus, в которой находится тег
+type RegisterType uint8
+
+const (
+	HOLDING_REGISTER RegisterType = iota
+	INPUT_REGISTER
+	COIL
+	DISCRETE_INPUT
+)
+
+func (r RegisterType) String() string {
+	switch r {
+	case HOLDING_REGISTER:
+		return "holding"
+	case INPUT_REGISTER:
+		return "input"
+	case COIL:
+		return "coil"
+	case DISCRETE_INPUT:
+		return "discrete"
+	}
+	return "unknown"
+}
+
+// isBit Таблица хранит однобитовые значения
+func (r RegisterType) isBit() bool {
+	return r == COIL || r == DISCRETE_INPUT
+}
+
+// Writable В таблицу можно писать
+func (r RegisterType) Writable() bool {
+	return r == HOLDING_REGISTER || r == COIL
+}
+
+// ParseRegisterType Разбор типа регистра из конфигурации, пустая строка это holding
+func ParseRegisterType(s string) (RegisterType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "holding", "holding_register":
+		return HOLDING_REGISTER, nil
+	case "input", "input_register":
+		return INPUT_REGISTER, nil
+	case "coil":
+		return COIL, nil
+	case "discrete", "discrete_input":
+		return DISCRETE_INPUT, nil
+	}
+
+	return HOLDING_REGISTER, fmt.Errorf("unsupported register type %s, must be holding, input, coil or discrete", s)
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/simonvetter/modbus"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Коды функций modbus, используются в метках метрик
+const (
+	FC_READ_COILS               uint8 = 0x01
+	FC_READ_DISCRETE_INPUTS     uint8 = 0x02
+	FC_READ_HOLDING_REGISTERS   uint8 = 0x03
+	FC_READ_INPUT_REGISTERS     uint8 = 0x04
+	FC_WRITE_SINGLE_COIL        uint8 = 0x05
+	FC_WRITE_SINGLE_REGISTER    uint8 = 0x06
+	FC_WRITE_MULTIPLE_REGISTERS uint8 = 0x10
+)
+
+// Коды исключений modbus для ошибок библиотеки
+var exceptionCodes = map[modbus.Error]uint8{
+	modbus.ErrIllegalFunction:         0x01,
+	modbus.ErrIllegalDataAddress:      0x02,
+	modbus.ErrIllegalDataValue:        0x03,
+	modbus.ErrServerDeviceFailure:     0x04,
+	modbus.ErrAcknowledge:             0x05,
+	modbus.ErrServerDeviceBusy:        0x06,
+	modbus.ErrMemoryParityError:       0x08,
+	modbus.ErrGWPathUnavailable:       0x0a,
+	modbus.ErrGWTargetFailedToRespond: 0x0b,
+}
+
+// readFunction Код функции чтения таблицы
+func readFunction(regType RegisterType) uint8 {
+	switch regType {
+	case INPUT_REGISTER:
+		return FC_READ_INPUT_REGISTERS
+	case COIL:
+		return FC_READ_COILS
+	case DISCRETE_INPUT:
+		return FC_READ_DISCRETE_INPUTS
+	}
+	return FC_READ_HOLDING_REGISTERS
+}
+
+// writeFunction Код функции записи count регистров
+func writeFunction(count int) uint8 {
+	if count == 1 {
+		return FC_WRITE_SINGLE_REGISTER
+	}
+	return FC_WRITE_MULTIPLE_REGISTERS
+}
+
+// errorClass Класс ошибки для метрик: timeout, crc, exception_N, protocol, io или other
+func errorClass(err error) string {
+	var mbErr modbus.Error
+	if errors.As(err, &mbErr) {
+		if code, ok := exceptionCodes[mbErr]; ok {
+			return fmt.Sprintf("exception_%d", code)
+		}
+		switch mbErr {
+		case modbus.ErrRequestTimedOut:
+			return "timeout"
+		case modbus.ErrBadCRC:
+			return "crc"
+		case modbus.ErrShortFrame, modbus.ErrProtocolError, modbus.ErrBadUnitId,
+			modbus.ErrBadTransactionId, modbus.ErrUnknownProtocolId:
+			return "protocol"
+		}
+		return "other"
+	}
+
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	if netErr != nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "io"
+	}
+
+	return "other"
+}
+
+// isException Устройство ответило исключением modbus
+func isException(err error) bool {
+	var mbErr modbus.Error
+	if errors.As(err, &mbErr) {
+		_, ok := exceptionCodes[mbErr]
+		return ok
+	}
+	return false
+}
+
+// transportError Ошибка самого соединения (сокет, порт, рассинхронизация кадров), после
+// неё соединение переоткрывается. Таймауты, ошибки CRC и исключения относятся к одному
+// устройству на шине.
+func transportError(err error) bool {
+	switch errorClass(err) {
+	case "timeout", "crc":
+		return false
+	}
+	return !isException(err)
+}
+
+// observe Учитывает запрос к шине в метриках: запросы, ошибки и время ответа по устройству
+// и функции, запросы и ошибки по тегам. Функция в метриках тегов отделяет чтения от записей.
+func (c *Connection) observe(dev *Device, fc uint8, tags []*Tag, start time.Time, err error) {
+	metrics.GetOrCreateHistogram(fmt.Sprintf("modbus_response_duration_seconds{connection=%q,device=%q,function=\"%d\"}",
+		c.conf.Name, dev.Name, fc)).UpdateDuration(start)
+	metrics.GetOrCreateCounter(fmt.Sprintf("modbus_requests_total{connection=%q,device=%q,function=\"%d\"}",
+		c.conf.Name, dev.Name, fc)).Inc()
+	for _, tag := range tags {
+		metrics.GetOrCreateCounter(fmt.Sprintf("modbus_tag_requests_total{device=%q,tag=%q,function=\"%d\"}",
+			dev.Name, tag.Name, fc)).Inc()
+	}
+
+	if err == nil {
+		return
+	}
+
+	class := errorClass(err)
+	metrics.GetOrCreateCounter(fmt.Sprintf("modbus_errors_total{connection=%q,device=%q,function=\"%d\",error=%q}",
+		c.conf.Name, dev.Name, fc, class)).Inc()
+	for _, tag := range tags {
+		metrics.GetOrCreateCounter(fmt.Sprintf("modbus_tag_errors_total{device=%q,tag=%q,function=\"%d\",error=%q}",
+			dev.Name, tag.Name, fc, class)).Inc()
+	}
+}
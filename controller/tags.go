@@ -1,17 +1,49 @@
 package controller
 
-import "github.com/VictoriaMetrics/metrics"
+import (
+	"github.com/VictoriaMetrics/metrics"
+	"math"
+	"time"
+)
 
 type Tag struct {
-	Name        string
-	DisplayName string
-	Group       string
-	Address     uint16
-	Action      func(interface{}, *Tag)
-	Method      uint8
-	LastValue   interface{}
-	Gauge       *metrics.Gauge
-	controller  *Controller
+	Name          string
+	DisplayName   string
+	Group         string
+	Device        *Device
+	Address       uint16
+	RegisterType  RegisterType
+	Type          DataType
+	Length        uint16   // Длина строки в регистрах
+	Encoding      Encoding // Порядок байт и регистров, если не задан то берется с устройства
+	Scale         float64  // Множитель: значение = регистр * Scale + Offset, 0 означает без масштаба
+	Offset        float64
+	Unit          string           // Единицы измерения
+	Expr          *Expr            // Выражение вычисляемого тега, такой тег не читается с шины
+	Parent        *Tag             // Регистр, из которого берется бит, для битовых тегов
+	Bit           uint8            // Номер бита в регистре Parent
+	Values        map[int64]string // Названия состояний по коду значения
+	Min           *float64         // Ограничения на запись, nil если не заданы
+	Max           *float64
+	Step          float64 // Шаг значения при записи, 0 без ограничения
+	Verify        bool    // Перечитать значение после записи и сравнить
+	Action        func(interface{}, *Tag)
+	Method        uint8
+	Interval      time.Duration // Период опроса тега, если 0 то тег читается каждый цикл
+	LastValue     interface{}
+	LastRead      time.Time         // Время последнего успешного чтения
+	NextDue       time.Time         // Время, когда тег нужно прочитать снова
+	LastError     string            // Последняя ошибка чтения
+	LastErrorTime time.Time         // Время последней ошибки
+	StaleAfter    time.Duration     // Порог устаревания значения, если 0 то берется с соединения
+	Labels        map[string]string // Дополнительные метки метрики
+	Metric        string            // Имя метрики значения вместе с метками
+	Gauge         *metrics.Gauge    // Метрика значения, у строковых тегов nil
+	controller    *Controller
+
+	bits    []*Tag          // Битовые теги этого регистра
+	deps    map[string]*Tag // Теги из выражения
+	exprErr string          // Последняя ошибка вычисления
 }
 
 func (t *Tag) GetName() string {
@@ -20,3 +52,83 @@ func (t *Tag) GetName() string {
 	}
 	return t.Name
 }
+
+// FullName Имя тега вместе с устройством: "device/name"
+func (t *Tag) FullName() string {
+	if t.Device == nil {
+		return t.Name
+	}
+	return t.Device.Name + "/" + t.Name
+}
+
+// due Пора ли читать тег
+func (t *Tag) due(now time.Time) bool {
+	return !now.Before(t.NextDue)
+}
+
+// markRead Запоминает время чтения и планирует следующее
+func (t *Tag) markRead(now time.Time) {
+	t.LastRead = now
+	t.NextDue = now.Add(t.Interval)
+}
+
+// encoding Порядок байт и регистров тега с учетом настроек устройства
+func (t *Tag) encoding() Encoding {
+	return t.Encoding.inherit(t.Device.Encoding)
+}
+
+func (t *Tag) scaled() bool {
+	return (t.Scale != 0 && t.Scale != 1) || t.Offset != 0
+}
+
+// fromRaw Переводит прочитанное значение в инженерные единицы
+func (t *Tag) fromRaw(val interface{}) interface{} {
+	if !t.scaled() {
+		return val
+	}
+
+	v, ok := toFloat(val)
+	if !ok {
+		return val
+	}
+
+	scale := t.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return v*scale + t.Offset
+}
+
+// toRaw Переводит значение в инженерных единицах в значение регистра
+func (t *Tag) toRaw(value float64) float64 {
+	if !t.scaled() {
+		return value
+	}
+
+	scale := t.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	raw := (value - t.Offset) / scale
+
+	// Для целых типов округляем, чтобы 21.5 / 0.1 не превратилось в 214
+	if t.Type != TYPE_FLOAT32 && t.Type != TYPE_FLOAT64 {
+		raw = math.Round(raw)
+	}
+	return raw
+}
+
+// Label Название текущего состояния тега по карте Values
+func (t *Tag) Label() (string, bool) {
+	if len(t.Values) == 0 || t.LastValue == nil {
+		return "", false
+	}
+
+	v, ok := toFloat(t.LastValue)
+	if !ok {
+		return "", false
+	}
+
+	label, ok := t.Values[int64(v)]
+	return label, ok
+}
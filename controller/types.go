@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DataType Тип значения тега, определяет сколько регистров он занимает и как декодируется
+type DataType uint8
+
+const (
+	TYPE_DEFAULT DataType = iota // Тип не задан, определяется по операции
+	TYPE_UINT16
+	TYPE_INT16
+	TYPE_UINT32
+	TYPE_INT32
+	TYPE_UINT64
+	TYPE_INT64
+	TYPE_FLOAT32
+	TYPE_FLOAT64
+	TYPE_STRING // ASCII строка, два символа в регистре, длина задается в регистрах
+	TYPE_BCD16  // Четыре десятичные цифры в одном регистре
+	TYPE_BCD32  // Восемь десятичных цифр в двух регистрах
+	TYPE_BOOL
+)
+
+var dataTypeNames = map[DataType]string{
+	TYPE_UINT16:  "uint16",
+	TYPE_INT16:   "int16",
+	TYPE_UINT32:  "uint32",
+	TYPE_INT32:   "int32",
+	TYPE_UINT64:  "uint64",
+	TYPE_INT64:   "int64",
+	TYPE_FLOAT32: "float32",
+	TYPE_FLOAT64: "float64",
+	TYPE_STRING:  "string",
+	TYPE_BCD16:   "bcd16",
+	TYPE_BCD32:   "bcd32",
+	TYPE_BOOL:    "bool",
+}
+
+func (d DataType) String() string {
+	if name, ok := dataTypeNames[d]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseDataType Разбор типа из конфигурации, пустая строка означает тип по операции
+func ParseDataType(s string) (DataType, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return TYPE_DEFAULT, nil
+	case "uint", "word":
+		return TYPE_UINT16, nil
+	case "int":
+		return TYPE_INT16, nil
+	case "float":
+		return TYPE_FLOAT32, nil
+	case "double":
+		return TYPE_FLOAT64, nil
+	case "bcd":
+		return TYPE_BCD16, nil
+	}
+
+	for d, name := range dataTypeNames {
+		if name == s {
+			return d, nil
+		}
+	}
+
+	return TYPE_DEFAULT, fmt.Errorf("unsupported type %s", s)
+}
+
+// regCount Количество регистров, которое занимает значение, для строк length задает длину в регистрах
+func (d DataType) regCount(length uint16) uint16 {
+	switch d {
+	case TYPE_UINT32, TYPE_INT32, TYPE_FLOAT32, TYPE_BCD32:
+		return 2
+	case TYPE_UINT64, TYPE_INT64, TYPE_FLOAT64:
+		return 4
+	case TYPE_STRING:
+		if length == 0 {
+			return 1
+		}
+		return length
+	}
+	return 1
+}
+
+// Numeric Значение можно представить числом (для метрик и записи)
+func (d DataType) Numeric() bool {
+	return d != TYPE_STRING
+}
+
+// joinRegs Склеивает регистры в число, первый регистр старший
+func joinRegs(regs []uint16) (v uint64) {
+	for _, r := range regs {
+		v = v<<16 | uint64(r)
+	}
+	return
+}
+
+// splitRegs Раскладывает число на count регистров, первый регистр старший
+func splitRegs(v uint64, count uint16) []uint16 {
+	regs := make([]uint16, count)
+	for i := int(count) - 1; i >= 0; i-- {
+		regs[i] = uint16(v)
+		v >>= 16
+	}
+	return regs
+}
+
+func decodeBcd(v uint64, digits int) uint64 {
+	var res uint64
+	var mul uint64 = 1
+	for i := 0; i < digits; i++ {
+		res += (v & 0xf) * mul
+		v >>= 4
+		mul *= 10
+	}
+	return res
+}
+
+func encodeBcd(v uint64, digits int) uint64 {
+	var res uint64
+	for i := 0; i < digits; i++ {
+		res |= (v % 10) << (4 * i)
+		v /= 10
+	}
+	return res
+}
+
+// decode Декодирует значение из регистров, regs должен содержать regCount регистров
+func (d DataType) decode(regs []uint16) interface{} {
+	switch d {
+	case TYPE_INT16:
+		return int16(regs[0])
+	case TYPE_UINT32:
+		return uint32(joinRegs(regs[:2]))
+	case TYPE_INT32:
+		return int32(joinRegs(regs[:2]))
+	case TYPE_UINT64:
+		return joinRegs(regs[:4])
+	case TYPE_INT64:
+		return int64(joinRegs(regs[:4]))
+	case TYPE_FLOAT32:
+		return math.Float32frombits(uint32(joinRegs(regs[:2])))
+	case TYPE_FLOAT64:
+		return math.Float64frombits(joinRegs(regs[:4]))
+	case TYPE_STRING:
+		b := make([]byte, 0, len(regs)*2)
+		for _, r := range regs {
+			b = append(b, byte(r>>8), byte(r))
+		}
+		return strings.TrimRight(string(b), "\x00 ")
+	case TYPE_BCD16:
+		return uint16(decodeBcd(uint64(regs[0]), 4))
+	case TYPE_BCD32:
+		return uint32(decodeBcd(joinRegs(regs[:2]), 8))
+	case TYPE_BOOL:
+		return regs[0] != 0
+	}
+	return regs[0]
+}
+
+// integer Тип хранит целые числа
+func (d DataType) integer() bool {
+	switch d {
+	case TYPE_UINT16, TYPE_INT16, TYPE_UINT32, TYPE_INT32, TYPE_UINT64, TYPE_INT64, TYPE_BCD16, TYPE_BCD32:
+		return true
+	}
+	return false
+}
+
+// Наибольшие float64, которые еще помещаются в 64-битные целые: сами MaxUint64 и MaxInt64
+// во float64 округляются вверх до 2^64 и 2^63 и при преобразовании переполняются
+var (
+	maxUint64Float = math.Nextafter(math.MaxUint64, 0)
+	maxInt64Float  = math.Nextafter(math.MaxInt64, 0)
+)
+
+// checkRange Проверяет что значение помещается в тип
+func checkRange(value float64, min float64, max float64) error {
+	if value < min || value > max || math.IsNaN(value) {
+		return valueErrorf("value %s out of range [%s, %s]",
+			strconv.FormatFloat(value, 'f', -1, 64),
+			strconv.FormatFloat(min, 'f', -1, 64),
+			strconv.FormatFloat(max, 'f', -1, 64))
+	}
+	return nil
+}
+
+// encode Кодирует число в регистры, значения вне диапазона типа и дробные значения для целых
+// типов отклоняются
+func (d DataType) encode(value float64) (regs []uint16, err error) {
+	// Дробная часть в целый регистр не пишется, отбрасывать её молча нельзя
+	if d.integer() && !math.IsNaN(value) && value != math.Trunc(value) {
+		return nil, valueErrorf("value %s is not an integer", strconv.FormatFloat(value, 'f', -1, 64))
+	}
+
+	switch d {
+	case TYPE_UINT16:
+		if err = checkRange(value, 0, math.MaxUint16); err == nil {
+			regs = []uint16{uint16(value)}
+		}
+	case TYPE_INT16:
+		if err = checkRange(value, math.MinInt16, math.MaxInt16); err == nil {
+			regs = []uint16{uint16(int16(value))}
+		}
+	case TYPE_UINT32:
+		if err = checkRange(value, 0, math.MaxUint32); err == nil {
+			regs = splitRegs(uint64(value), 2)
+		}
+	case TYPE_INT32:
+		if err = checkRange(value, math.MinInt32, math.MaxInt32); err == nil {
+			regs = splitRegs(uint64(uint32(int32(value))), 2)
+		}
+	case TYPE_UINT64:
+		if err = checkRange(value, 0, maxUint64Float); err == nil {
+			regs = splitRegs(uint64(value), 4)
+		}
+	case TYPE_INT64:
+		if err = checkRange(value, math.MinInt64, maxInt64Float); err == nil {
+			regs = splitRegs(uint64(int64(value)), 4)
+		}
+	case TYPE_FLOAT32:
+		regs = splitRegs(uint64(math.Float32bits(float32(value))), 2)
+	case TYPE_FLOAT64:
+		regs = splitRegs(math.Float64bits(value), 4)
+	case TYPE_BCD16:
+		if err = checkRange(value, 0, 9999); err == nil {
+			regs = []uint16{uint16(encodeBcd(uint64(value), 4))}
+		}
+	case TYPE_BCD32:
+		if err = checkRange(value, 0, 99999999); err == nil {
+			regs = splitRegs(encodeBcd(uint64(value), 8), 2)
+		}
+	case TYPE_BOOL:
+		regs = []uint16{0}
+		if value != 0 {
+			regs[0] = 1
+		}
+	default:
+		err = fmt.Errorf("type %s can not be written", d)
+	}
+
+	return
+}
+
+// toFloat Приводит декодированное значение к float64
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case uint16:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// formatValue Строковое представление декодированного значения
+func formatValue(val interface{}) string {
+	switch v := val.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', 2, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case string:
+		return v
+	case uint16, int16, uint32, int32, uint64, int64:
+		return fmt.Sprintf("%d", v)
+	}
+	return "unknown"
+}
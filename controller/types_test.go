@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestTypeRoundTrip(t *testing.T) {
+	// regs в порядке декодера: big endian, старший регистр первый
+	tests := []struct {
+		typ   DataType
+		value float64
+		regs  []uint16
+		want  interface{}
+	}{
+		{TYPE_UINT16, 0, []uint16{0}, uint16(0)},
+		{TYPE_UINT16, 54321, []uint16{54321}, uint16(54321)},
+		{TYPE_UINT16, math.MaxUint16, []uint16{0xffff}, uint16(math.MaxUint16)},
+		{TYPE_INT16, -1, []uint16{0xffff}, int16(-1)},
+		{TYPE_INT16, math.MinInt16, []uint16{0x8000}, int16(math.MinInt16)},
+		{TYPE_INT16, math.MaxInt16, []uint16{0x7fff}, int16(math.MaxInt16)},
+		{TYPE_UINT32, 0x12345678, []uint16{0x1234, 0x5678}, uint32(0x12345678)},
+		{TYPE_UINT32, math.MaxUint32, []uint16{0xffff, 0xffff}, uint32(math.MaxUint32)},
+		{TYPE_INT32, -2, []uint16{0xffff, 0xfffe}, int32(-2)},
+		{TYPE_INT32, math.MinInt32, []uint16{0x8000, 0}, int32(math.MinInt32)},
+		{TYPE_UINT64, 0x0001000200030004, []uint16{1, 2, 3, 4}, uint64(0x0001000200030004)},
+		{TYPE_UINT64, 1 << 63, []uint16{0x8000, 0, 0, 0}, uint64(1 << 63)},
+		{TYPE_UINT64, maxUint64Float, []uint16{0xffff, 0xffff, 0xffff, 0xf800}, uint64(0xfffffffffffff800)},
+		{TYPE_INT64, maxInt64Float, []uint16{0x7fff, 0xffff, 0xffff, 0xfc00}, int64(0x7ffffffffffffc00)},
+		{TYPE_INT64, -3, []uint16{0xffff, 0xffff, 0xffff, 0xfffd}, int64(-3)},
+		{TYPE_INT64, math.MinInt64, []uint16{0x8000, 0, 0, 0}, int64(math.MinInt64)},
+		{TYPE_FLOAT32, 1.5, []uint16{0x3fc0, 0}, float32(1.5)},
+		{TYPE_FLOAT32, -21.75, []uint16{0xc1ae, 0}, float32(-21.75)},
+		{TYPE_FLOAT64, 1.5, []uint16{0x3ff8, 0, 0, 0}, float64(1.5)},
+		{TYPE_FLOAT64, -0.1, []uint16{0xbfb9, 0x9999, 0x9999, 0x999a}, float64(-0.1)},
+		{TYPE_BCD16, 1234, []uint16{0x1234}, uint16(1234)},
+		{TYPE_BCD16, 9999, []uint16{0x9999}, uint16(9999)},
+		{TYPE_BCD32, 12345678, []uint16{0x1234, 0x5678}, uint32(12345678)},
+		{TYPE_BCD32, 905, []uint16{0, 0x0905}, uint32(905)},
+		{TYPE_BOOL, 1, []uint16{1}, true},
+		{TYPE_BOOL, 0, []uint16{0}, false},
+	}
+
+	for _, tt := range tests {
+		regs, err := tt.typ.encode(tt.value)
+		if err != nil {
+			t.Errorf("%s.encode(%v) error: %s", tt.typ, tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(regs, tt.regs) {
+			t.Errorf("%s.encode(%v) = %#04x, want %#04x", tt.typ, tt.value, regs, tt.regs)
+		}
+		if len(regs) != int(tt.typ.regCount(0)) {
+			t.Errorf("%s.encode(%v) returned %d registers, regCount %d", tt.typ, tt.value, len(regs), tt.typ.regCount(0))
+		}
+		if got := tt.typ.decode(tt.regs); got != tt.want {
+			t.Errorf("%s.decode(%#04x) = %v (%T), want %v (%T)", tt.typ, tt.regs, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestTypeDecodeString(t *testing.T) {
+	tests := []struct {
+		regs []uint16
+		want string
+	}{
+		{[]uint16{0x4142, 0x4344}, "ABCD"},
+		{[]uint16{0x4142, 0x4300}, "ABC"},
+		{[]uint16{0x4142, 0x2020, 0}, "AB"},
+		{[]uint16{0, 0}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := TYPE_STRING.decode(tt.regs); got != tt.want {
+			t.Errorf("decode(%#04x) = %q, want %q", tt.regs, got, tt.want)
+		}
+	}
+	if _, err := TYPE_STRING.encode(1); err == nil {
+		t.Errorf("string encode must fail")
+	}
+	if n := TYPE_STRING.regCount(8); n != 8 {
+		t.Errorf("string regCount(8) = %d, want 8", n)
+	}
+}
+
+func TestTypeEncodeRange(t *testing.T) {
+	tests := []struct {
+		typ   DataType
+		value float64
+	}{
+		{TYPE_UINT16, -1},
+		{TYPE_UINT16, math.MaxUint16 + 1},
+		{TYPE_INT16, math.MinInt16 - 1},
+		{TYPE_INT16, math.MaxInt16 + 1},
+		{TYPE_UINT32, -1},
+		{TYPE_UINT32, math.MaxUint32 + 1},
+		{TYPE_INT32, math.MinInt32 - 1},
+		{TYPE_INT32, math.MaxInt32 + 1},
+		{TYPE_UINT64, -1},
+		{TYPE_UINT64, math.MaxUint64},
+		{TYPE_INT64, math.MaxInt64},
+		{TYPE_INT64, math.Inf(-1)},
+		{TYPE_BCD16, -1},
+		{TYPE_BCD16, 10000},
+		{TYPE_BCD32, 100000000},
+		{TYPE_UINT16, math.NaN()},
+		// Дробные значения для целых типов
+		{TYPE_UINT16, 21.7},
+		{TYPE_INT16, -1.5},
+		{TYPE_UINT32, 0.5},
+		{TYPE_INT64, 1e-9},
+		{TYPE_BCD16, 12.5},
+		{TYPE_INT32, math.Inf(1)},
+	}
+
+	for _, tt := range tests {
+		regs, err := tt.typ.encode(tt.value)
+		var valueErr *ValueError
+		if !errors.As(err, &valueErr) {
+			t.Errorf("%s.encode(%v) = %#04x, %v, want ValueError", tt.typ, tt.value, regs, err)
+		}
+	}
+}
+
+func TestEncodingReorder(t *testing.T) {
+	abcd := Encoding{BIG_ENDIAN, HIGH_WORD_FIRST}
+	cdab := Encoding{BIG_ENDIAN, LOW_WORD_FIRST}
+	badc := Encoding{LITTLE_ENDIAN, HIGH_WORD_FIRST}
+	dcba := Encoding{LITTLE_ENDIAN, LOW_WORD_FIRST}
+
+	// device Регистры в порядке устройства
+	tests := []struct {
+		enc    Encoding
+		typ    DataType
+		device []uint16
+		want   interface{}
+	}{
+		{abcd, TYPE_FLOAT32, []uint16{0x3fc0, 0x0000}, float32(1.5)},
+		{cdab, TYPE_FLOAT32, []uint16{0x0000, 0x3fc0}, float32(1.5)},
+		{badc, TYPE_FLOAT32, []uint16{0xc03f, 0x0000}, float32(1.5)},
+		{dcba, TYPE_FLOAT32, []uint16{0x0000, 0xc03f}, float32(1.5)},
+		{cdab, TYPE_UINT32, []uint16{0x5678, 0x1234}, uint32(0x12345678)},
+		{dcba, TYPE_INT32, []uint16{0xfeff, 0xffff}, int32(-2)},
+		{cdab, TYPE_UINT64, []uint16{4, 3, 2, 1}, uint64(0x0001000200030004)},
+		{badc, TYPE_INT64, []uint16{0xffff, 0xffff, 0xffff, 0xfdff}, int64(-3)},
+		{dcba, TYPE_FLOAT64, []uint16{0, 0, 0, 0xf83f}, float64(1.5)},
+		{cdab, TYPE_BCD32, []uint16{0x5678, 0x1234}, uint32(12345678)},
+		{badc, TYPE_UINT16, []uint16{0x3412}, uint16(0x1234)},
+		{dcba, TYPE_INT16, []uint16{0xffff}, int16(-1)},
+		// Порядок регистров строки не меняется, меняются только байты
+		{cdab, TYPE_STRING, []uint16{0x4142, 0x4344}, "ABCD"},
+		{dcba, TYPE_STRING, []uint16{0x4241, 0x4443}, "ABCD"},
+	}
+
+	for _, tt := range tests {
+		regs := tt.enc.reorder(tt.device, tt.typ)
+		if got := tt.typ.decode(regs); got != tt.want {
+			t.Errorf("%s/%s %s decode(%#04x) = %v, want %v", tt.enc.ByteOrder, tt.enc.WordOrder, tt.typ, tt.device, got, tt.want)
+			continue
+		}
+		// Преобразование симметричное: запись возвращает исходные регистры устройства
+		if back := tt.enc.reorder(regs, tt.typ); !reflect.DeepEqual(back, tt.device) {
+			t.Errorf("%s/%s %s reorder back = %#04x, want %#04x", tt.enc.ByteOrder, tt.enc.WordOrder, tt.typ, back, tt.device)
+		}
+		if f, ok := toFloat(tt.want); ok {
+			encoded, err := tt.typ.encode(f)
+			if err != nil {
+				t.Errorf("%s.encode(%v) error: %s", tt.typ, f, err)
+				continue
+			}
+			if device := tt.enc.reorder(encoded, tt.typ); !reflect.DeepEqual(device, tt.device) {
+				t.Errorf("%s/%s %s encode(%v) = %#04x, want %#04x", tt.enc.ByteOrder, tt.enc.WordOrder, tt.typ, f, device, tt.device)
+			}
+		}
+	}
+
+	// reorder не меняет переданный срез
+	src := []uint16{1, 2}
+	dcba.reorder(src, TYPE_UINT32)
+	if !reflect.DeepEqual(src, []uint16{1, 2}) {
+		t.Errorf("reorder modified source: %#04x", src)
+	}
+}
+
+func TestValidateRaw(t *testing.T) {
+	tests := []struct {
+		tag   Tag
+		value float64
+		ok    bool
+	}{
+		{Tag{Type: TYPE_UINT16}, 21, true},
+		{Tag{Type: TYPE_UINT16}, 21.7, false},
+		{Tag{Type: TYPE_UINT16}, -1, false},
+		{Tag{Type: TYPE_FLOAT32}, 21.7, true},
+		// Масштабированные значения округляются до целого регистра
+		{Tag{Type: TYPE_UINT16, Scale: 0.1}, 21.7, true},
+		{Tag{Type: TYPE_INT16, Scale: 0.1, Offset: -50}, -60.04, true},
+		{Tag{Type: TYPE_UINT16, Scale: 0.1}, 6553.6, false},
+		{Tag{Type: TYPE_UINT64}, 1.8446744073709552e19, false},
+		{Tag{Type: TYPE_BOOL}, 1, true},
+	}
+
+	for _, tt := range tests {
+		err := tt.tag.Validate(tt.value)
+		if tt.ok && err != nil {
+			t.Errorf("%s scale %v: Validate(%v) error: %s", tt.tag.Type, tt.tag.Scale, tt.value, err)
+		}
+		if !tt.ok && !IsValueError(err) {
+			t.Errorf("%s scale %v: Validate(%v) = %v, want ValueError", tt.tag.Type, tt.tag.Scale, tt.value, err)
+		}
+	}
+}
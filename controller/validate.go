@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ValueError Недопустимое для записи значение, запись в шину не выполнялась
+type ValueError struct {
+	msg string
+}
+
+func (e *ValueError) Error() string {
+	return e.msg
+}
+
+func valueErrorf(format string, args ...interface{}) error {
+	return &ValueError{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsValueError Ошибка вызвана недопустимым значением, а не обменом с устройством
+func IsValueError(err error) bool {
+	var valueErr *ValueError
+	return errors.As(err, &valueErr)
+}
+
+func formatNum(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Validate Проверяет значение перед записью: min, max, шаг и диапазон типа регистра
+func (t *Tag) Validate(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return valueErrorf("value %s is not a number", formatNum(value))
+	}
+	if t.Min != nil && value < *t.Min {
+		return valueErrorf("value %s is less than min %s", formatNum(value), formatNum(*t.Min))
+	}
+	if t.Max != nil && value > *t.Max {
+		return valueErrorf("value %s is greater than max %s", formatNum(value), formatNum(*t.Max))
+	}
+
+	if t.Step > 0 {
+		// Шаг отсчитывается от min, если он задан
+		base := 0.0
+		if t.Min != nil {
+			base = *t.Min
+		}
+		n := (value - base) / t.Step
+		if math.Abs(n-math.Round(n)) > 1e-9*math.Max(1, math.Abs(n)) {
+			return valueErrorf("value %s does not match step %s", formatNum(value), formatNum(t.Step))
+		}
+	}
+
+	// Значение в единицах регистра должно помещаться в его тип
+	if _, err := t.Type.encode(t.toRaw(value)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Limits Описание ограничений для подсказки пользователю, например "10..90, шаг 0.5"
+func (t *Tag) Limits() string {
+	var res string
+	if t.Min != nil || t.Max != nil {
+		if t.Min != nil {
+			res += formatNum(*t.Min)
+		}
+		res += ".."
+		if t.Max != nil {
+			res += formatNum(*t.Max)
+		}
+	}
+	if t.Step > 0 {
+		if res != "" {
+			res += ", "
+		}
+		res += "шаг " + formatNum(t.Step)
+	}
+	return res
+}
+
+// verifyValue Сравнивает перечитанное значение тега с записанным
+func (t *Tag) verifyValue(written float64) error {
+	got, ok := toFloat(t.LastValue)
+	if !ok {
+		return fmt.Errorf("verify %s: no value read back", t.FullName())
+	}
+
+	if t.Type == TYPE_BOOL {
+		written = boolToFloat(written != 0)
+	}
+
+	// Допуск на округление: половина шага масштаба для целых и точность float32
+	tol := 1e-9 * math.Max(1, math.Abs(written))
+	if t.Type == TYPE_FLOAT32 {
+		tol = 1e-6 * math.Max(1, math.Abs(written))
+	}
+	if t.scaled() && t.Scale != 0 {
+		tol = math.Max(tol, math.Abs(t.Scale)/2)
+	}
+
+	if math.Abs(got-written) > tol {
+		return fmt.Errorf("verify %s: read back %s, written %s", t.FullName(), formatNum(got), formatNum(written))
+	}
+
+	return nil
+}
@@ -4,7 +4,9 @@ go 1.25
 
 require (
 	github.com/VictoriaMetrics/metrics v1.24.0
+	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	github.com/golang/snappy v1.0.0
 	github.com/mcuadros/go-defaults v1.2.0
 	github.com/simonvetter/modbus v1.6.0
 	gopkg.in/yaml.v2 v2.4.0
@@ -12,7 +14,10 @@ require (
 
 require (
 	github.com/goburrow/serial v0.1.0 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/valyala/fastrand v1.1.0 // indirect
 	github.com/valyala/histogram v1.2.0 // indirect
-	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+	golang.org/x/sys v0.36.0 // indirect
 )
@@ -0,0 +1,224 @@
+package history
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/mcuadros/go-defaults"
+	"log"
+	"math"
+	"modbus2prometheus/controller"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config Настройки локальной истории значений тегов
+type Config struct {
+	Retention    time.Duration `default:"24h"`   // Сколько хранить значения
+	MaxPoints    int           `default:"86400"` // Максимум значений одного тега
+	File         string        // Файл для сохранения истории между перезапусками, если пусто то только в памяти
+	SaveInterval time.Duration `default:"5m"`
+	Ctrl         *controller.Controller
+}
+
+// Bucket Значения тега за интервал step, начиная с Time
+type Bucket struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"`
+}
+
+// Store История значений тегов в кольцевых буферах, по одному на тег
+type Store struct {
+	conf    Config
+	updates <-chan controller.Update
+
+	mu     sync.RWMutex
+	series map[string]*ring // Ключ - полное имя тега device/name
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New Загружает сохраненную историю и начинает запись, вызывается до запуска опроса контроллера
+func New(conf Config) (*Store, error) {
+	defaults.SetDefaults(&conf)
+	if conf.MaxPoints <= 0 {
+		return nil, fmt.Errorf("history max points must be positive")
+	}
+
+	s := &Store{
+		conf:   conf,
+		series: make(map[string]*ring),
+		done:   make(chan struct{}),
+	}
+	if conf.File != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+	s.updates = conf.Ctrl.Subscribe()
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Stop Останавливает запись и сохраняет историю
+func (s *Store) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Store) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.conf.SaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			s.save()
+			return
+		case u, ok := <-s.updates:
+			if !ok {
+				s.save()
+				return
+			}
+			if val, ok := controller.ValToFloat(u.Value); ok && !math.IsNaN(val) && !math.IsInf(val, 0) {
+				s.add(u.Tag.FullName(), Point{u.Time.UnixMilli(), val})
+			}
+		case <-ticker.C:
+			s.save()
+		}
+	}
+}
+
+func (s *Store) add(name string, p Point) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.series[name]
+	if r == nil {
+		r = newRing(s.conf.MaxPoints)
+		s.series[name] = r
+	}
+	r.trim(p.Time - s.conf.Retention.Milliseconds())
+	r.push(p)
+}
+
+// Query Значения тега за [from, to]. Если step > 0, то значения сводятся в интервалы
+// по step с минимумом, максимумом и средним, иначе возвращается каждое значение.
+func (s *Store) Query(name string, from, to time.Time, step time.Duration) []Bucket {
+	s.mu.RLock()
+	var points []Point
+	if r := s.series[name]; r != nil {
+		points = r.slice(from.UnixMilli(), to.UnixMilli())
+	}
+	s.mu.RUnlock()
+
+	return Downsample(points, step)
+}
+
+// Downsample Сводит значения в интервалы по step с минимумом, максимумом и средним,
+// границы интервалов кратны step. Если step не задан, то каждое значение отдельно.
+func Downsample(points []Point, step time.Duration) []Bucket {
+	res := []Bucket{}
+	if step <= 0 {
+		for _, p := range points {
+			res = append(res, Bucket{time.UnixMilli(p.Time), p.Value, p.Value, p.Value, 1})
+		}
+		return res
+	}
+
+	stepMs := step.Milliseconds()
+	if stepMs == 0 {
+		stepMs = 1
+	}
+	var cur *Bucket
+	var start int64
+	var sum float64
+	for _, p := range points {
+		bucketStart := p.Time - p.Time%stepMs
+		if cur == nil || bucketStart != start {
+			if cur != nil {
+				cur.Avg = sum / float64(cur.Count)
+				res = append(res, *cur)
+			}
+			start, sum = bucketStart, 0
+			cur = &Bucket{Time: time.UnixMilli(start), Min: math.Inf(1), Max: math.Inf(-1)}
+		}
+		cur.Min = math.Min(cur.Min, p.Value)
+		cur.Max = math.Max(cur.Max, p.Value)
+		cur.Count++
+		sum += p.Value
+	}
+	if cur != nil {
+		cur.Avg = sum / float64(cur.Count)
+		res = append(res, *cur)
+	}
+
+	return res
+}
+
+// save Сохраняет историю в файл через временный файл, чтобы сбой не испортил сохраненное
+func (s *Store) save() {
+	if s.conf.File == "" {
+		return
+	}
+
+	s.mu.RLock()
+	data := make(map[string][]Point, len(s.series))
+	for name, r := range s.series {
+		data[name] = r.slice(math.MinInt64, math.MaxInt64)
+	}
+	s.mu.RUnlock()
+
+	tmp := s.conf.File + ".tmp"
+	file, err := os.Create(tmp)
+	if err == nil {
+		err = gob.NewEncoder(file).Encode(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.conf.File)
+	}
+	if err != nil {
+		log.Printf("History save error: %s", err.Error())
+	}
+}
+
+// load Загружает историю из файла, устаревшие значения отбрасываются
+func (s *Store) load() error {
+	file, err := os.Open(s.conf.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var data map[string][]Point
+	if err := gob.NewDecoder(file).Decode(&data); err != nil {
+		return fmt.Errorf("history %s: %w", s.conf.File, err)
+	}
+
+	before := time.Now().Add(-s.conf.Retention).UnixMilli()
+	for name, points := range data {
+		for _, p := range points {
+			if p.Time >= before {
+				s.add(name, p)
+			}
+		}
+	}
+	log.Printf("History loaded from %s: %d tags", s.conf.File, len(data))
+
+	return nil
+}
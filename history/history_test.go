@@ -0,0 +1,156 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDownsample(t *testing.T) {
+	points := []Point{
+		{0, 1},
+		{59999, 3},
+		{60000, 10},
+		{119999, 20},
+		// Пустой интервал 120000-179999 пропускается
+		{180000, -5},
+	}
+
+	tests := []struct {
+		name   string
+		points []Point
+		step   time.Duration
+		want   []Bucket
+	}{
+		{"no points", nil, time.Minute, []Bucket{}},
+		{"every point", points[:2], 0, []Bucket{
+			{time.UnixMilli(0), 1, 1, 1, 1},
+			{time.UnixMilli(59999), 3, 3, 3, 1},
+		}},
+		{"minute buckets", points, time.Minute, []Bucket{
+			{time.UnixMilli(0), 1, 3, 2, 2},
+			{time.UnixMilli(60000), 10, 20, 15, 2},
+			{time.UnixMilli(180000), -5, -5, -5, 1},
+		}},
+		{"one bucket", points, time.Hour, []Bucket{
+			{time.UnixMilli(0), -5, 20, 29.0 / 5, 5},
+		}},
+		{"step below millisecond", points[:2], time.Microsecond, []Bucket{
+			{time.UnixMilli(0), 1, 1, 1, 1},
+			{time.UnixMilli(59999), 3, 3, 3, 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := Downsample(tt.points, tt.step); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Downsample() =\n%v\nwant\n%v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	for i := int64(1); i <= 5; i++ {
+		r.push(Point{i * 1000, float64(i)})
+	}
+	// Переполнение отбрасывает самые старые значения
+	if want := []Point{{3000, 3}, {4000, 4}, {5000, 5}}; !reflect.DeepEqual(r.points, want) {
+		t.Errorf("after overflow %v, want %v", r.points, want)
+	}
+
+	if got, want := r.slice(4000, 5000), []Point{{4000, 4}, {5000, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("slice(4000, 5000) = %v, want %v", got, want)
+	}
+	if got := r.slice(6000, 7000); got != nil {
+		t.Errorf("slice(6000, 7000) = %v, want none", got)
+	}
+
+	r.trim(4000)
+	if want := []Point{{4000, 4}, {5000, 5}}; !reflect.DeepEqual(r.points, want) {
+		t.Errorf("after trim(4000) %v, want %v", r.points, want)
+	}
+	r.trim(10000)
+	if len(r.points) != 0 {
+		t.Errorf("after trim(10000) %v, want empty", r.points)
+	}
+	r.push(Point{11000, 11})
+	if want := []Point{{11000, 11}}; !reflect.DeepEqual(r.points, want) {
+		t.Errorf("push after trim %v, want %v", r.points, want)
+	}
+}
+
+// values Значения без сведения в интервалы
+func values(buckets []Bucket) (res []float64) {
+	for _, b := range buckets {
+		res = append(res, b.Min)
+	}
+	return
+}
+
+func TestStoreRetention(t *testing.T) {
+	s := &Store{conf: Config{Retention: time.Hour, MaxPoints: 4}, series: make(map[string]*ring)}
+	start := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) int64 { return start.Add(d).UnixMilli() }
+
+	s.add("boiler/temp", Point{at(0), 1})
+	s.add("boiler/temp", Point{at(30 * time.Minute), 2})
+	s.add("boiler/temp", Point{at(60 * time.Minute), 3})
+	s.add("boiler/other", Point{at(0), 100})
+
+	// Значение ровно на границе Retention еще хранится
+	got := s.Query("boiler/temp", start, start.Add(2*time.Hour), 0)
+	if len(got) != 3 {
+		t.Fatalf("Query() = %v, want 3 points", got)
+	}
+
+	// Новое значение вытесняет все, что старше часа, другие теги не затрагиваются
+	s.add("boiler/temp", Point{at(90 * time.Minute), 4})
+	if got, want := values(s.Query("boiler/temp", start, start.Add(2*time.Hour), 0)), []float64{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() after retention = %v, want %v", got, want)
+	}
+	if got := s.Query("boiler/other", start, start.Add(2*time.Hour), 0); len(got) != 1 {
+		t.Errorf("Query(other) = %v, want 1 point", got)
+	}
+
+	// MaxPoints ограничивает число значений тега внутри Retention
+	for i := 1; i <= 5; i++ {
+		s.add("boiler/temp", Point{at(90*time.Minute + time.Duration(i)*time.Second), float64(10 + i)})
+	}
+	if got, want := values(s.Query("boiler/temp", start, start.Add(2*time.Hour), 0)), []float64{12, 13, 14, 15}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() after overflow = %v, want %v", got, want)
+	}
+	if got := s.Query("boiler/missing", start, start.Add(time.Hour), 0); len(got) != 0 {
+		t.Errorf("Query(missing) = %v, want none", got)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		s    string
+		want time.Time
+		ok   bool
+	}{
+		{"2026-10-18T08:50:38Z", time.Date(2026, 10, 18, 8, 50, 38, 0, time.UTC), true},
+		{"2026-10-18T11:50:38+03:00", time.Date(2026, 10, 18, 8, 50, 38, 0, time.UTC), true},
+		{"1760777438", time.Unix(1760777438, 0), true},
+		{"1760777438.5", time.UnixMilli(1760777438500), true},
+		{"12h", now.Add(-12 * time.Hour), true},
+		{"-90m", now.Add(-90 * time.Minute), true},
+		{"0s", now, true},
+		{"", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"2026-10-18", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTime(tt.s, now)
+		if tt.ok && (err != nil || !got.Equal(tt.want)) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ParseTime(%q) = %v, want error", tt.s, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JsonHistory Ответ /api/v1/history
+type JsonHistory struct {
+	Tag    string   `json:"tag"`
+	Unit   string   `json:"unit,omitempty"`
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Step   string   `json:"step,omitempty"`
+	Points []Bucket `json:"points"`
+}
+
+// ParseTime Разбор времени запроса: RFC3339, unix время в секундах или длительность
+// назад от now, например "12h" или "-12h"
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.UnixMilli(int64(sec * 1000)), nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("bad time %q", s)
+}
+
+func badRequest(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte("Bad Request: " + err.Error()))
+}
+
+// Handler GET /api/v1/history?tag=device/name&from=...&to=...&step=...
+// По умолчанию отдается последний час без сведения в интервалы.
+func (s *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+
+		tag := s.conf.Ctrl.FindTag(q.Get("tag"))
+		if tag == nil {
+			badRequest(w, fmt.Errorf("tag %q not found", q.Get("tag")))
+			return
+		}
+
+		now := time.Now()
+		from, to := now.Add(-time.Hour), now
+		var step time.Duration
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = ParseTime(v, now); err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = ParseTime(v, now); err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+		if v := q.Get("step"); v != "" {
+			if step, err = time.ParseDuration(v); err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+
+		res := JsonHistory{
+			Tag:    tag.FullName(),
+			Unit:   tag.Unit,
+			From:   from.Format(time.RFC3339),
+			To:     to.Format(time.RFC3339),
+			Points: s.Query(tag.FullName(), from, to, step),
+		}
+		if step > 0 {
+			res.Step = step.String()
+		}
+
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Println("Cannot send response")
+		}
+	}
+}
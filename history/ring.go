@@ -0,0 +1,45 @@
+package history
+
+// Point Значение тега в момент времени, время в миллисекундах
+type Point struct {
+	Time  int64
+	Value float64
+}
+
+// ring Буфер значений одного тега не больше size, при переполнении отбрасываются самые
+// старые. Память выделяется по мере заполнения, отброшенное начало освобождается
+// при очередном расширении слайса.
+type ring struct {
+	points []Point
+	size   int
+}
+
+func newRing(size int) *ring {
+	return &ring{size: size}
+}
+
+func (r *ring) push(p Point) {
+	if len(r.points) >= r.size {
+		r.points = r.points[len(r.points)-r.size+1:]
+	}
+	r.points = append(r.points, p)
+}
+
+// trim Удаляет значения старше before
+func (r *ring) trim(before int64) {
+	i := 0
+	for i < len(r.points) && r.points[i].Time < before {
+		i++
+	}
+	r.points = r.points[i:]
+}
+
+// slice Копия значений в интервале [from, to] по порядку
+func (r *ring) slice(from, to int64) (res []Point) {
+	for _, p := range r.points {
+		if p.Time >= from && p.Time <= to {
+			res = append(res, p)
+		}
+	}
+	return
+}
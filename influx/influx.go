@@ -0,0 +1,264 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mcuadros/go-defaults"
+	"io"
+	"log"
+	"modbus2prometheus/controller"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Максимальный размер UDP пакета, строки не разрываются между пакетами
+const maxDatagram = 1400
+
+// Config Настройки записи значений тегов в InfluxDB
+type Config struct {
+	Url           string // http(s)://host:8086 для /api/v2/write или udp://host:8089
+	Org           string
+	Bucket        string
+	Token         string
+	Measurement   string        `default:"modbus"`
+	BatchSize     int           `default:"1000"`   // Сколько строк отправлять одним запросом
+	FlushInterval time.Duration `default:"10s"`    // Период отправки и повтора после ошибки
+	Timeout       time.Duration `default:"10s"`    // Таймаут одного запроса
+	MaxBuffer     int           `default:"100000"` // Сколько строк копить, пока InfluxDB недоступна
+	Ctrl          *controller.Controller
+}
+
+// Writer Копит строки line protocol и отправляет их пачками в отдельной горутине,
+// недоступность InfluxDB не влияет на опрос
+type Writer struct {
+	conf    Config
+	send    func(lines []string) error
+	updates <-chan controller.Update
+
+	mu      sync.Mutex
+	queue   []string
+	dropped int // Сколько строк отброшено из начала очереди при переполнении
+
+	kick chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New Запускает запись до вызова Stop, вызывается до запуска опроса контроллера
+func New(conf Config) (*Writer, error) {
+	defaults.SetDefaults(&conf)
+
+	u, err := url.Parse(conf.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		conf: conf,
+		kick: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		w.send, err = newHttpSender(conf, u)
+	case "udp":
+		w.send, err = newUdpSender(u)
+	default:
+		err = fmt.Errorf("influx url %s: unsupported scheme", conf.Url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.updates = conf.Ctrl.Subscribe()
+
+	w.wg.Add(2)
+	go w.collect()
+	go w.run()
+
+	return w, nil
+}
+
+// Stop Останавливает запись, накопленное отправляется последней попыткой
+func (w *Writer) Stop() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+// collect Переводит обновления тегов в строки и складывает их в очередь
+func (w *Writer) collect() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case u, ok := <-w.updates:
+			if !ok {
+				return
+			}
+			l, ok := line(w.conf.Measurement, u)
+			if !ok {
+				continue
+			}
+
+			w.mu.Lock()
+			w.queue = append(w.queue, l)
+			if over := len(w.queue) - w.conf.MaxBuffer; over > 0 {
+				w.queue = w.queue[over:]
+				w.dropped += over
+			}
+			full := len(w.queue) >= w.conf.BatchSize
+			w.mu.Unlock()
+
+			if full {
+				select {
+				case w.kick <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// run Отправляет очередь по таймеру или при наборе полной пачки. После ошибки
+// следующая попытка будет только по таймеру.
+func (w *Writer) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.conf.FlushInterval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		select {
+		case <-w.done:
+			w.flush()
+			return
+		case <-w.kick:
+			if !failing {
+				failing = !w.flush()
+			}
+		case <-ticker.C:
+			failing = !w.flush()
+		}
+	}
+}
+
+// flush Отправляет очередь пачками, возвращает false при ошибке
+func (w *Writer) flush() bool {
+	for {
+		w.mu.Lock()
+		n := len(w.queue)
+		if n > w.conf.BatchSize {
+			n = w.conf.BatchSize
+		}
+		batch := w.queue[:n:n]
+		dropped := w.dropped
+		w.mu.Unlock()
+
+		if n == 0 {
+			return true
+		}
+
+		if err := w.send(batch); err != nil {
+			w.mu.Lock()
+			queued := len(w.queue)
+			w.mu.Unlock()
+			log.Printf("Influx write error: %s, %d lines queued", err.Error(), queued)
+			return false
+		}
+
+		// Пока шла отправка, часть пачки могла быть отброшена при переполнении
+		w.mu.Lock()
+		if sent := n - (w.dropped - dropped); sent > 0 {
+			w.queue = w.queue[sent:]
+		}
+		w.mu.Unlock()
+	}
+}
+
+// newHttpSender Отправка в /api/v2/write, если в адресе не указан путь
+func newHttpSender(conf Config, u *url.URL) (func([]string) error, error) {
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/api/v2/write"
+	}
+	q := u.Query()
+	if conf.Org != "" {
+		q.Set("org", conf.Org)
+	}
+	if conf.Bucket != "" {
+		q.Set("bucket", conf.Bucket)
+	}
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+	endpoint := u.String()
+
+	client := &http.Client{Timeout: conf.Timeout}
+
+	return func(lines []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+		defer cancel()
+
+		body := strings.Join(lines, "\n") + "\n"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if conf.Token != "" {
+			req.Header.Set("Authorization", "Token "+conf.Token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+
+		// Данные отвергнуты, повтор не поможет
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			log.Printf("Influx %s, %d lines dropped", err.Error(), len(lines))
+			return nil
+		}
+		return err
+	}, nil
+}
+
+// newUdpSender Отправка строк UDP пакетами не больше maxDatagram
+func newUdpSender(u *url.URL) (func([]string) error, error) {
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(lines []string) error {
+		var packet bytes.Buffer
+		for _, l := range lines {
+			if packet.Len() > 0 && packet.Len()+len(l)+1 > maxDatagram {
+				if _, err := conn.Write(packet.Bytes()); err != nil {
+					return err
+				}
+				packet.Reset()
+			}
+			packet.WriteString(l)
+			packet.WriteByte('\n')
+		}
+		if packet.Len() > 0 {
+			_, err := conn.Write(packet.Bytes())
+			return err
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,67 @@
+package influx
+
+import (
+	"math"
+	"modbus2prometheus/controller"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// line Строка line protocol для значения тега. Числа и логические значения пишутся
+// в поле value как float, строки в поле text, чтобы тип поля не зависел от тега.
+// NaN и бесконечности line protocol не допускает, такие значения пропускаются.
+func line(measurement string, u controller.Update) (string, bool) {
+	var field string
+	if val, ok := controller.ValToFloat(u.Value); ok {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return "", false
+		}
+		field = "value=" + strconv.FormatFloat(val, 'f', -1, 64)
+	} else if s, ok := u.Value.(string); ok {
+		field = `text="` + stringEscaper.Replace(s) + `"`
+	} else {
+		return "", false
+	}
+
+	tag := u.Tag
+	tags := map[string]string{
+		"device": tag.Device.Name,
+		"tag":    tag.Name,
+		"group":  tag.Group,
+		"unit":   tag.Unit,
+	}
+	for k, v := range tag.Labels {
+		tags[k] = v
+	}
+
+	// Теги line protocol пишутся отсортированными по ключу, пустые значения не допускаются
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(tagEscaper.Replace(tags[k]))
+	}
+	b.WriteByte(' ')
+	b.WriteString(field)
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(u.Time.UnixNano(), 10))
+
+	return b.String(), true
+}
@@ -0,0 +1,43 @@
+package influx
+
+import (
+	"math"
+	"modbus2prometheus/controller"
+	"testing"
+	"time"
+)
+
+func TestLine(t *testing.T) {
+	ts := time.Unix(1700000000, 5)
+	dev := &controller.Device{Name: "boiler"}
+	temp := &controller.Tag{Name: "temp", Device: dev, Group: "heat", Unit: "°C"}
+	serial := &controller.Tag{Name: "serial", Device: dev, Labels: map[string]string{"room": "boiler room", "a": "x=1,y"}}
+
+	tests := []struct {
+		measurement string
+		u           controller.Update
+		want        string
+		ok          bool
+	}{
+		{"modbus", controller.Update{Tag: temp, Value: float32(21.5), Time: ts},
+			"modbus,device=boiler,group=heat,tag=temp,unit=°C value=21.5 1700000000000000005", true},
+		{"modbus", controller.Update{Tag: temp, Value: uint16(7), Time: ts},
+			"modbus,device=boiler,group=heat,tag=temp,unit=°C value=7 1700000000000000005", true},
+		{"modbus", controller.Update{Tag: temp, Value: true, Time: ts},
+			"modbus,device=boiler,group=heat,tag=temp,unit=°C value=1 1700000000000000005", true},
+		{"modbus values", controller.Update{Tag: serial, Value: `SN "1"\2`, Time: ts},
+			`modbus\ values,a=x\=1\,y,device=boiler,room=boiler\ room,tag=serial text="SN \"1\"\\2" 1700000000000000005`, true},
+		// Значения, недопустимые в line protocol
+		{"modbus", controller.Update{Tag: temp, Value: math.NaN(), Time: ts}, "", false},
+		{"modbus", controller.Update{Tag: temp, Value: float32(math.Inf(1)), Time: ts}, "", false},
+		{"modbus", controller.Update{Tag: temp, Value: math.Inf(-1), Time: ts}, "", false},
+		{"modbus", controller.Update{Tag: temp, Value: []uint16{1}, Time: ts}, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := line(tt.measurement, tt.u)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("line(%v) = %q, %v, want %q, %v", tt.u.Value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/mcuadros/go-defaults"
 	"log"
+	"modbus2prometheus/alerts"
+	"modbus2prometheus/connwatch"
 	"modbus2prometheus/controller"
+	"modbus2prometheus/history"
+	"modbus2prometheus/influx"
+	"modbus2prometheus/mqtt"
+	"modbus2prometheus/remotewrite"
 	"modbus2prometheus/telegram"
 	"modbus2prometheus/telegram/commands"
+	"modbus2prometheus/tsdb"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const APP = "modbus2prometheus"
@@ -19,7 +31,7 @@ var (
 	httpListenAddr = flag.String("httpListenAddr", ":9101", "TCP address to listen for http connections.")
 	modbusTcpAddr  = flag.String("modbusTcpAddr", "rtuovertcp://192.168.1.200:8899", "TCP address to modbus device with modbus TCP.")
 	configPath     = flag.String("config", "./config.yaml", "Modbus controller configuration")
-	maxAttempts    = flag.Uint("maxAttempts", 100, "Max attempts before fail exit")
+	maxAttempts    = flag.Uint("maxAttempts", 100, "Max attempts before connection is considered down")
 	botApiToken    = flag.String("botApiToken", "", "Telegram bot API token")
 
 	config *Config
@@ -27,61 +39,202 @@ var (
 
 // Инициализация модбас контроллера
 func initController() (ctrl *controller.Controller, err error) {
-	log.Println("Configuring modbus controller " + *modbusTcpAddr)
 	ctrl, err = controller.New(&controller.Configuration{
-		Url:         config.DeviceUrl,
-		DeviceId:    config.DeviceId,
-		Speed:       config.Speed,
-		Timeout:     config.Timeout,
-		PollingTime: config.PollingTime,
-		ReadPeriod:  config.ReadPeriod,
-		MaxAttempts: *maxAttempts,
+		MaxAttempts:  *maxAttempts,
+		MetricPrefix: config.MetricPrefix,
+		MetricFamily: config.MetricFamily,
 	})
 	if err != nil {
 		log.Println(err.Error())
 		os.Exit(1)
 	}
 
-	for _, tag := range config.Tags {
-		ctrl.AddTag(&controller.Tag{
-			Name:        tag.Name,
-			DisplayName: tag.Desc,
-			Group:       tag.Group,
-			Address:     tag.Address,
-			Method:      controller.ParseOperation(tag.Operation)})
+	for _, connConf := range config.AllConnections() {
+		log.Println("Configuring modbus connection " + connConf.Url)
+		conn, err := ctrl.AddConnection(&controller.ConnectionConfiguration{
+			Name:         connConf.Name,
+			Url:          connConf.Url,
+			Speed:        connConf.Speed,
+			Timeout:      connConf.Timeout,
+			PollingTime:  connConf.PollingTime,
+			ReadPeriod:   connConf.ReadPeriod,
+			MaxGap:       connConf.MaxGap,
+			MaxBlock:     connConf.MaxBlock,
+			WriteTimeout: connConf.WriteTimeout,
+			StaleAfter:   connConf.StaleAfter,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, devConf := range connConf.Devices {
+			dev, err := conn.AddDevice(devConf.Name, devConf.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			dev.Encoding, err = ParseEncoding(devConf.ByteOrder, devConf.WordOrder)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, tag := range devConf.Tags {
+				regType, err := controller.ParseRegisterType(tag.RegisterType)
+				if err != nil {
+					return nil, err
+				}
+
+				dataType, err := controller.ParseDataType(tag.Type)
+				if err != nil {
+					return nil, err
+				}
+
+				encoding, err := ParseEncoding(tag.ByteOrder, tag.WordOrder)
+				if err != nil {
+					return nil, err
+				}
+
+				// Вычисляемый тег
+				var expr *controller.Expr
+				if tag.Expr != "" {
+					expr, err = controller.ParseExpr(tag.Expr)
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				// По умолчанию тег только читается
+				operation := tag.Operation
+				if operation == "" {
+					operation = "read"
+				}
+
+				parent := &controller.Tag{
+					Name:         tag.Name,
+					DisplayName:  tag.Desc,
+					Group:        tag.Group,
+					Device:       dev,
+					Address:      tag.Address,
+					RegisterType: regType,
+					Type:         dataType,
+					Length:       tag.Length,
+					Encoding:     encoding,
+					Scale:        tag.Scale,
+					Offset:       tag.Offset,
+					Unit:         tag.Unit,
+					Expr:         expr,
+					Values:       tag.Values,
+					Min:          tag.Min,
+					Max:          tag.Max,
+					Step:         tag.Step,
+					Verify:       tag.Verify,
+					Interval:     config.TagInterval(tag),
+					StaleAfter:   tag.StaleAfter,
+					Labels:       tag.Labels,
+				}
+				parent.Method, err = controller.ParseOperation(operation)
+				if err != nil {
+					return nil, err
+				}
+				err = ctrl.AddTag(parent)
+				if err != nil {
+					return nil, err
+				}
+
+				// Битовые теги читаются вместе с родительским регистром
+				for _, bit := range tag.Bits {
+					name := bit.Name
+					if name == "" {
+						name = controller.BitTagName(tag.Name, bit.Bit)
+					}
+					group := bit.Group
+					if group == "" {
+						group = tag.Group
+					}
+					operation := bit.Operation
+					if operation == "" {
+						operation = "read"
+					}
+
+					method, err := controller.ParseOperation(operation)
+					if err != nil {
+						return nil, err
+					}
+
+					err = ctrl.AddTag(&controller.Tag{
+						Name:        name,
+						DisplayName: bit.Desc,
+						Group:       group,
+						Parent:      parent,
+						Bit:         bit.Bit,
+						Values:      bit.Values,
+						Labels:      bit.Labels,
+						Method:      method})
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
 	}
 
-	// Запуск полера
-	go ctrl.Poll()
-	defer ctrl.Close()
+	err = ctrl.ResolveComputed()
+	if err != nil {
+		return nil, err
+	}
 
 	return
 }
 
 // Инициализация сервера http для выдачи состояния и метрик
-func initHttpServer(ctrl *controller.Controller) *http.ServeMux {
+func initHttpServer(ctrl *controller.Controller, hist *history.Store, db *tsdb.Store, alerter *alerts.Manager) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.Handle("/tags", controller.TagsHahdler(ctrl))
 	mux.Handle("/api/v1/write", ctrl.WriteTagsHandler())
 	mux.Handle("/metrics", MetricsHandler())
+	if hist != nil {
+		mux.Handle("/api/v1/history", hist.Handler())
+	}
+	if db != nil {
+		mux.Handle("/api/v1/tsdb/query", db.Handler())
+	}
+	if alerter != nil {
+		mux.Handle("/api/v1/alerts", alerter.Handler())
+		mux.Handle("/api/v1/silences", alerter.SilencesHandler())
+	}
 
 	return mux
 }
 
 // initTelegram инициализация телеграм бота из конфига
-func initTelegram(ctrl *controller.Controller) {
+func initTelegram(ctrl *controller.Controller, alerter *alerts.Manager, watcher *connwatch.Watcher) (*telegram.BotState, error) {
+	if config.Telegram.ApiToken == "" {
+		if alerter != nil || watcher != nil {
+			log.Println("Telegram bot is not configured, alerts are only available over HTTP and the webhook")
+		}
+		return nil, nil
+	}
 
 	listFn := func(group string) func() string {
 		return func() string {
+			// Значения тегов пишут горутины полеров
+			ctrl.RLock()
+			defer ctrl.RUnlock()
+
 			var repl string
-			for _, tag := range ctrl.Tags() {
-				if group == tag.Group || group == "" {
-					if tag.DisplayName != "" {
-						repl += tag.DisplayName + ": " + controller.ValToStr(tag) + "\n"
-					} else {
-						repl += tag.Name + ": " + controller.ValToStr(tag) + "\n"
+			devices := ctrl.Devices()
+			for _, dev := range devices {
+				var devRepl string
+				for _, tag := range dev.Tags() {
+					if group == tag.Group || group == "" {
+						devRepl += tag.GetName() + ": " + controller.ValToStrWithUnit(tag) + "\n"
 					}
 				}
+				// Если устройств несколько, то подписываем к какому относятся значения
+				if len(devices) > 1 && devRepl != "" {
+					devRepl = "[" + dev.Name + "]\n" + devRepl
+				}
+				repl += devRepl
 			}
 			return repl
 		}
@@ -106,8 +259,163 @@ func initTelegram(ctrl *controller.Controller) {
 		commands.NewUstCommand(ctrl),
 		commands.NewSensorsCommand(config.Telegram.NodeRedUrl + "/current_th"),
 	}
+	if alerter != nil {
+		apiCommands = append(apiCommands,
+			commands.NewAlertsCommand(alerter),
+			commands.NewSilenceCommand(alerter, config.Telegram.Owners),
+		)
+	}
+
+	return telegram.New(telegram.BotConfig{
+		BotToken: config.Telegram.ApiToken,
+		Owners:   config.Telegram.Owners,
+		Api:      apiCommands,
+		Ctrl:     ctrl,
+		Alerts:   alerter,
+		Conns:    watcher,
+	})
+}
+
+// initMqtt инициализация публикации в MQTT, если в конфиге задан брокер
+func initMqtt(ctrl *controller.Controller) (*mqtt.Publisher, error) {
+	if config.Mqtt.Url == "" {
+		return nil, nil
+	}
 
-	telegram.New(telegram.BotConfig{config.Telegram.ApiToken, config.Telegram.Owners, apiCommands, ctrl})
+	return mqtt.New(mqtt.Config{
+		Url:             config.Mqtt.Url,
+		ClientId:        config.Mqtt.ClientId,
+		Username:        config.Mqtt.Username,
+		Password:        config.Mqtt.Password,
+		Topic:           config.Mqtt.Topic,
+		Interval:        config.Mqtt.Interval,
+		Qos:             config.Mqtt.Qos,
+		Retain:          config.Mqtt.Retain,
+		Discovery:       config.Mqtt.Discovery,
+		DiscoveryPrefix: config.Mqtt.DiscoveryPrefix,
+		Ctrl:            ctrl,
+	})
+}
+
+// initRemoteWrite инициализация отправки метрик по remote_write, если в конфиге задан адрес
+func initRemoteWrite(ctrl *controller.Controller) (*remotewrite.Pusher, error) {
+	if config.RemoteWrite.Url == "" {
+		return nil, nil
+	}
+
+	return remotewrite.New(remotewrite.Config{
+		Url:         config.RemoteWrite.Url,
+		Interval:    config.RemoteWrite.Interval,
+		Timeout:     config.RemoteWrite.Timeout,
+		Username:    config.RemoteWrite.Username,
+		Password:    config.RemoteWrite.Password,
+		BearerToken: config.RemoteWrite.BearerToken,
+		BufferDir:   config.RemoteWrite.BufferDir,
+		MaxBuffer:   config.RemoteWrite.MaxBuffer,
+		Ctrl:        ctrl,
+	})
+}
+
+// initInflux инициализация записи в InfluxDB, если в конфиге задан адрес
+func initInflux(ctrl *controller.Controller) (*influx.Writer, error) {
+	if config.Influx.Url == "" {
+		return nil, nil
+	}
+
+	return influx.New(influx.Config{
+		Url:           config.Influx.Url,
+		Org:           config.Influx.Org,
+		Bucket:        config.Influx.Bucket,
+		Token:         config.Influx.Token,
+		Measurement:   config.Influx.Measurement,
+		BatchSize:     config.Influx.BatchSize,
+		FlushInterval: config.Influx.FlushInterval,
+		Timeout:       config.Influx.Timeout,
+		MaxBuffer:     config.Influx.MaxBuffer,
+		Ctrl:          ctrl,
+	})
+}
+
+// initHistory инициализация локальной истории значений
+func initHistory(ctrl *controller.Controller) (*history.Store, error) {
+	if !config.History.Enabled {
+		return nil, nil
+	}
+
+	return history.New(history.Config{
+		Retention:    config.History.Retention,
+		MaxPoints:    config.History.MaxPoints,
+		File:         config.History.File,
+		SaveInterval: config.History.SaveInterval,
+		Ctrl:         ctrl,
+	})
+}
+
+// initTsdb инициализация хранилища значений на диске, если в конфиге задан каталог
+func initTsdb(ctrl *controller.Controller) (*tsdb.Store, error) {
+	if config.Tsdb.Dir == "" {
+		return nil, nil
+	}
+
+	return tsdb.New(tsdb.Config{
+		Dir:            config.Tsdb.Dir,
+		Retention:      config.Tsdb.Retention,
+		BlockDuration:  config.Tsdb.BlockDuration,
+		SyncInterval:   config.Tsdb.SyncInterval,
+		MaxQueryPoints: config.Tsdb.MaxQueryPoints,
+		Backfill: remotewrite.Config{
+			Url:         config.Tsdb.Backfill.Url,
+			Username:    config.Tsdb.Backfill.Username,
+			Password:    config.Tsdb.Backfill.Password,
+			BearerToken: config.Tsdb.Backfill.BearerToken,
+			Timeout:     config.Tsdb.Backfill.Timeout,
+		},
+		BackfillInterval: config.Tsdb.Backfill.Interval,
+		Ctrl:             ctrl,
+	})
+}
+
+// initAlerts инициализация тревог, если в конфиге есть правила
+func initAlerts(ctrl *controller.Controller) (*alerts.Manager, error) {
+	if len(config.Alerts.Rules) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]alerts.RuleConfig, 0, len(config.Alerts.Rules))
+	for _, rule := range config.Alerts.Rules {
+		rules = append(rules, alerts.RuleConfig{
+			Name:     rule.Name,
+			Expr:     rule.Expr,
+			Clear:    rule.Clear,
+			For:      rule.For,
+			Repeat:   rule.Repeat,
+			Severity: rule.Severity,
+			Message:  rule.Message,
+		})
+	}
+
+	return alerts.New(alerts.Config{
+		Rules:       rules,
+		Interval:    config.Alerts.Interval,
+		SilenceFile: config.Alerts.SilenceFile,
+		Ctrl:        ctrl,
+	})
+}
+
+// initConnWatch инициализация уведомлений о состоянии связи с устройствами
+func initConnWatch(ctrl *controller.Controller) (*connwatch.Watcher, error) {
+	if !config.ConnAlerts.Enabled {
+		return nil, nil
+	}
+
+	return connwatch.New(connwatch.Config{
+		Debounce:   config.ConnAlerts.Debounce,
+		Webhook:    config.ConnAlerts.Webhook,
+		Timeout:    config.ConnAlerts.Timeout,
+		Retries:    config.ConnAlerts.Retries,
+		RetryDelay: config.ConnAlerts.RetryDelay,
+		Ctrl:       ctrl,
+	})
 }
 
 func ParseFlags() {
@@ -147,6 +455,10 @@ func main() {
 	ParseFlags()
 	log.Println("Starting...")
 
+	// Остановка по SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Инициализация модбас конроллера
 	ctrl, err := initController()
 	if err != nil {
@@ -154,17 +466,118 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Запуск телеграм бота, управления домом
-	initTelegram(ctrl)
+	// Публикация в MQTT подписывается на обновления до запуска полера
+	publisher, err := initMqtt(ctrl)
+	if err != nil {
+		log.Println("Can not start mqtt: " + err.Error())
+	}
+	pusher, err := initRemoteWrite(ctrl)
+	if err != nil {
+		log.Println("Can not start remote write: " + err.Error())
+	}
+	influxWriter, err := initInflux(ctrl)
+	if err != nil {
+		log.Println("Can not start influx: " + err.Error())
+	}
+	hist, err := initHistory(ctrl)
+	if err != nil {
+		log.Println("Can not start history: " + err.Error())
+	}
 
-	// Инициализация сервера
-	mux := initHttpServer(ctrl)
-	log.Println("Listening " + *httpListenAddr + " ...")
-	err = http.ListenAndServe(*httpListenAddr, mux)
+	// Хранилище и тревоги настроены, но не запускаются - это ошибка конфигурации. Молча
+	// работать без истории или без уведомлений об авариях нельзя, поэтому выходим.
+	db, err := initTsdb(ctrl)
 	if err != nil {
-		log.Println("Can not listen http: " + err.Error())
+		log.Println("Can not start tsdb: " + err.Error())
+		os.Exit(1)
+	}
+	alerter, err := initAlerts(ctrl)
+	if err != nil {
+		log.Println("Can not start alerts: " + err.Error())
 		os.Exit(1)
 	}
+	watcher, err := initConnWatch(ctrl)
+	if err != nil {
+		log.Println("Can not start connection alerts: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Запуск полера
+	ctrl.Start(ctx)
+
+	// Запуск телеграм бота, управления домом. Без сети бот подключается в фоне, а отвергнутый
+	// токен - ошибка конфигурации, как и у тревог: без канала уведомлений не работаем
+	bot, err := initTelegram(ctrl, alerter, watcher)
+	if err != nil {
+		log.Println("Can not start telegram bot: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Проверка тревог запускается после подписки бота на уведомления
+	if alerter != nil {
+		alerter.Start()
+	}
+	if watcher != nil {
+		watcher.Start()
+	}
+
+	// Инициализация сервера
+	server := &http.Server{
+		Addr:    *httpListenAddr,
+		Handler: initHttpServer(ctrl, hist, db, alerter),
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Listening " + *httpListenAddr + " ...")
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
 
-	os.Exit(0)
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err := <-serverErr:
+		log.Println("Can not listen http: " + err.Error())
+		exitCode = 1
+	}
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Http shutdown error: " + err.Error())
+	}
+
+	if alerter != nil {
+		alerter.Stop()
+	}
+	if watcher != nil {
+		watcher.Stop()
+	}
+	if bot != nil {
+		bot.Stop()
+	}
+	if publisher != nil {
+		publisher.Stop()
+	}
+
+	ctrl.Stop()
+
+	// Последние значения уходят в буфер remote write после остановки опроса
+	if pusher != nil {
+		pusher.Stop()
+	}
+	if influxWriter != nil {
+		influxWriter.Stop()
+	}
+	if hist != nil {
+		hist.Stop()
+	}
+	if db != nil {
+		db.Stop()
+	}
+	log.Println("Stopped")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,129 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"modbus2prometheus/controller"
+	"sort"
+	"strings"
+)
+
+// haDevice Устройство Home Assistant, одно на каждое устройство modbus
+type haDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model,omitempty"`
+}
+
+// haEntity Конфигурация сущности Home Assistant MQTT discovery
+type haEntity struct {
+	Name              string   `json:"name"`
+	UniqueId          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic,omitempty"`
+	CommandTopic      string   `json:"command_topic,omitempty"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	ValueTemplate     string   `json:"value_template,omitempty"`
+	PayloadOn         string   `json:"payload_on,omitempty"`
+	PayloadOff        string   `json:"payload_off,omitempty"`
+	Min               *float64 `json:"min,omitempty"`
+	Max               *float64 `json:"max,omitempty"`
+	Step              float64  `json:"step,omitempty"`
+	Mode              string   `json:"mode,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+// objectId Идентификатор для топика discovery, допустимы только [a-zA-Z0-9_-]
+func objectId(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// component Тип сущности по типу тега: переключатель, число, бинарный датчик или датчик
+func component(tag *controller.Tag) string {
+	writable := controller.Writable(tag)
+	switch {
+	case tag.Type == controller.TYPE_BOOL && writable:
+		return "switch"
+	case tag.Type == controller.TYPE_BOOL:
+		return "binary_sensor"
+	case writable:
+		return "number"
+	}
+	return "sensor"
+}
+
+// valueTemplate Шаблон, заменяющий коды состояний их названиями
+func valueTemplate(values map[int64]string) string {
+	codes := make([]int64, 0, len(values))
+	for code := range values {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	var items []string
+	for _, code := range codes {
+		label, _ := json.Marshal(values[code])
+		items = append(items, fmt.Sprintf(`"%d": %s`, code, label))
+	}
+	return "{{ {" + strings.Join(items, ", ") + "}.get(value, value) }}"
+}
+
+// entity Конфигурация сущности тега
+func (p *Publisher) entity(tag *controller.Tag) haEntity {
+	devId := objectId(p.conf.Topic + "_" + tag.Device.Name)
+	e := haEntity{
+		Name:              tag.GetName(),
+		UniqueId:          objectId(p.conf.Topic + "_" + tag.Device.Name + "_" + tag.Name),
+		StateTopic:        p.stateTopic(tag),
+		AvailabilityTopic: p.statusTopic(),
+		Unit:              tag.Unit,
+		Device: haDevice{
+			Identifiers: []string{devId},
+			Name:        tag.Device.Name,
+			Model:       fmt.Sprintf("modbus unit %d", tag.Device.UnitId),
+		},
+	}
+
+	// Группа тега попадает в название, чтобы сущности одной группы были рядом
+	if tag.Group != "" {
+		e.Name = tag.Group + ": " + e.Name
+	}
+
+	switch component(tag) {
+	case "switch", "binary_sensor":
+		e.PayloadOn, e.PayloadOff = "ON", "OFF"
+	case "number":
+		e.Min, e.Max, e.Step, e.Mode = tag.Min, tag.Max, tag.Step, "box"
+	case "sensor":
+		if len(tag.Values) > 0 {
+			e.ValueTemplate = valueTemplate(tag.Values)
+			e.Unit = ""
+		}
+	}
+	if controller.Writable(tag) {
+		e.CommandTopic = p.commandTopic(tag)
+	}
+
+	return e
+}
+
+// publishDiscovery Публикует конфигурацию Home Assistant для всех тегов
+func (p *Publisher) publishDiscovery() {
+	for _, tag := range p.conf.Ctrl.Tags() {
+		data, err := json.Marshal(p.entity(tag))
+		if err != nil {
+			log.Printf("MQTT discovery %s error: %s", tag.FullName(), err.Error())
+			continue
+		}
+
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.conf.DiscoveryPrefix, component(tag),
+			objectId(p.conf.ClientId), objectId(tag.Device.Name+"_"+tag.Name))
+		p.client.Publish(topic, p.conf.Qos, true, data)
+	}
+}
@@ -0,0 +1,101 @@
+package mqtt
+
+import (
+	"modbus2prometheus/controller"
+	"reflect"
+	"testing"
+)
+
+func TestObjectId(t *testing.T) {
+	tests := map[string]string{
+		"boiler_temp":   "boiler_temp",
+		"boiler-1/temp": "boiler-1_temp",
+		"status.bit3":   "status_bit3",
+		"темп пола":     "_________",
+	}
+
+	for s, want := range tests {
+		if got := objectId(s); got != want {
+			t.Errorf("objectId(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestComponent(t *testing.T) {
+	tests := []struct {
+		tag  controller.Tag
+		want string
+	}{
+		{controller.Tag{Type: controller.TYPE_BOOL, Method: controller.READ | controller.WRITE}, "switch"},
+		{controller.Tag{Type: controller.TYPE_BOOL, Method: controller.READ}, "binary_sensor"},
+		{controller.Tag{Type: controller.TYPE_UINT16, Method: controller.READ | controller.WRITE}, "number"},
+		{controller.Tag{Type: controller.TYPE_FLOAT32, Method: controller.READ}, "sensor"},
+		{controller.Tag{Type: controller.TYPE_STRING, Method: controller.READ}, "sensor"},
+		{controller.Tag{Type: controller.TYPE_FLOAT64}, "sensor"},
+	}
+
+	for _, tt := range tests {
+		if got := component(&tt.tag); got != tt.want {
+			t.Errorf("component(%s, method %#x) = %s, want %s", tt.tag.Type, tt.tag.Method, got, tt.want)
+		}
+	}
+}
+
+func TestValueTemplate(t *testing.T) {
+	got := valueTemplate(map[int64]string{2: "Авария", 0: "Стоп", -1: `Режим "1"`})
+	want := `{{ {"-1": "Режим \"1\"", "0": "Стоп", "2": "Авария"}.get(value, value) }}`
+	if got != want {
+		t.Errorf("valueTemplate() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEntity(t *testing.T) {
+	p := &Publisher{conf: Config{Topic: "m2p"}}
+	dev := &controller.Device{Name: "boiler", UnitId: 3}
+	device := haDevice{Identifiers: []string{"m2p_boiler"}, Name: "boiler", Model: "modbus unit 3"}
+	min, max := 30.0, 75.0
+
+	tests := []struct {
+		tag  controller.Tag
+		want haEntity
+	}{
+		{
+			tag: controller.Tag{Name: "temp", DisplayName: "Температура", Group: "heat", Unit: "°C",
+				Type: controller.TYPE_FLOAT32, Method: controller.READ},
+			want: haEntity{Name: "heat: Температура", UniqueId: "m2p_boiler_temp", StateTopic: "m2p/boiler/temp",
+				AvailabilityTopic: "m2p/status", Unit: "°C", Device: device},
+		},
+		{
+			tag: controller.Tag{Name: "mode", Unit: "code", Type: controller.TYPE_UINT16, Method: controller.READ,
+				Values: map[int64]string{0: "off", 1: "on"}},
+			want: haEntity{Name: "mode", UniqueId: "m2p_boiler_mode", StateTopic: "m2p/boiler/mode",
+				AvailabilityTopic: "m2p/status", ValueTemplate: `{{ {"0": "off", "1": "on"}.get(value, value) }}`,
+				Device: device},
+		},
+		{
+			tag: controller.Tag{Name: "t_ust", Unit: "°C", Type: controller.TYPE_UINT16,
+				Method: controller.READ | controller.WRITE, Min: &min, Max: &max, Step: 0.5},
+			want: haEntity{Name: "t_ust", UniqueId: "m2p_boiler_t_ust", StateTopic: "m2p/boiler/t_ust",
+				CommandTopic: "m2p/boiler/t_ust/set", AvailabilityTopic: "m2p/status", Unit: "°C",
+				Min: &min, Max: &max, Step: 0.5, Mode: "box", Device: device},
+		},
+		{
+			tag: controller.Tag{Name: "status.bit3", Type: controller.TYPE_BOOL, Method: controller.READ | controller.WRITE},
+			want: haEntity{Name: "status.bit3", UniqueId: "m2p_boiler_status_bit3", StateTopic: "m2p/boiler/status.bit3",
+				CommandTopic: "m2p/boiler/status.bit3/set", AvailabilityTopic: "m2p/status",
+				PayloadOn: "ON", PayloadOff: "OFF", Device: device},
+		},
+		{
+			tag: controller.Tag{Name: "alarm", Type: controller.TYPE_BOOL, Method: controller.READ},
+			want: haEntity{Name: "alarm", UniqueId: "m2p_boiler_alarm", StateTopic: "m2p/boiler/alarm",
+				AvailabilityTopic: "m2p/status", PayloadOn: "ON", PayloadOff: "OFF", Device: device},
+		},
+	}
+
+	for _, tt := range tests {
+		tt.tag.Device = dev
+		if got := p.entity(&tt.tag); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("entity(%s) =\n%+v\nwant\n%+v", tt.tag.Name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mqtt
+
+import (
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mcuadros/go-defaults"
+	"log"
+	"modbus2prometheus/controller"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config Настройки публикации значений тегов в MQTT брокер
+type Config struct {
+	Url             string
+	ClientId        string `default:"modbus2prometheus"`
+	Username        string
+	Password        string
+	Topic           string        `default:"modbus2prometheus"` // Корневой топик, значения публикуются в Topic/device/tag
+	Interval        time.Duration `default:"1m"`                // Период публикации всех значений, изменения публикуются сразу
+	Qos             byte
+	Retain          bool
+	Discovery       bool   // Публиковать конфигурацию Home Assistant MQTT discovery
+	DiscoveryPrefix string `default:"homeassistant"`
+	Ctrl            *controller.Controller
+}
+
+// Publisher Публикует значения тегов и принимает команды записи из топиков Topic/device/tag/set
+type Publisher struct {
+	conf    Config
+	client  paho.Client
+	updates <-chan controller.Update
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New Подключается к брокеру и запускает публикацию до вызова Stop. Вызывается до
+// запуска опроса контроллера. Недоступный брокер не ошибка, подключение повторяется в фоне.
+func New(conf Config) (*Publisher, error) {
+	defaults.SetDefaults(&conf)
+	if conf.Url == "" {
+		return nil, fmt.Errorf("mqtt url is empty")
+	}
+
+	p := &Publisher{
+		conf:    conf,
+		updates: conf.Ctrl.Subscribe(),
+		done:    make(chan struct{}),
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(conf.Url).
+		SetClientID(conf.ClientId).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetWill(p.statusTopic(), "offline", conf.Qos, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("MQTT connection lost: %s", err.Error())
+		})
+	p.client = paho.NewClient(opts)
+	p.client.Connect()
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p, nil
+}
+
+// Stop Останавливает публикацию и отключается от брокера
+func (p *Publisher) Stop() {
+	close(p.done)
+	p.wg.Wait()
+
+	p.client.Publish(p.statusTopic(), p.conf.Qos, true, "offline").WaitTimeout(time.Second)
+	p.client.Disconnect(250)
+}
+
+func (p *Publisher) statusTopic() string {
+	return p.conf.Topic + "/status"
+}
+
+func (p *Publisher) stateTopic(tag *controller.Tag) string {
+	return p.conf.Topic + "/" + tag.Device.Name + "/" + tag.Name
+}
+
+func (p *Publisher) commandTopic(tag *controller.Tag) string {
+	return p.stateTopic(tag) + "/set"
+}
+
+// onConnect После каждого подключения подписываемся на команды и публикуем все заново
+func (p *Publisher) onConnect(client paho.Client) {
+	log.Printf("MQTT connected to %s", p.conf.Url)
+
+	client.Subscribe(p.conf.Topic+"/+/+/set", p.conf.Qos, p.onCommand)
+	client.Publish(p.statusTopic(), p.conf.Qos, true, "online")
+	if p.conf.Discovery {
+		p.publishDiscovery()
+	}
+	p.publishAll()
+}
+
+// run Публикует изменения сразу, а все значения раз в Interval
+func (p *Publisher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case u, ok := <-p.updates:
+			if !ok {
+				return
+			}
+			if u.Changed {
+				p.publish(u.Tag, u.Value)
+			}
+		case <-ticker.C:
+			p.publishAll()
+		}
+	}
+}
+
+// publishAll Публикует текущие значения всех тегов, устаревшие значения пропускаются
+func (p *Publisher) publishAll() {
+	type value struct {
+		tag *controller.Tag
+		val interface{}
+	}
+
+	var values []value
+	now := time.Now()
+	p.conf.Ctrl.RLock()
+	for _, tag := range p.conf.Ctrl.Tags() {
+		if tag.Quality(now) == controller.QUALITY_GOOD {
+			values = append(values, value{tag, tag.LastValue})
+		}
+	}
+	p.conf.Ctrl.RUnlock()
+
+	for _, v := range values {
+		p.publish(v.tag, v.val)
+	}
+}
+
+func (p *Publisher) publish(tag *controller.Tag, val interface{}) {
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+	p.client.Publish(p.stateTopic(tag), p.conf.Qos, p.conf.Retain, payload(val))
+}
+
+// payload Значение в топике: логические как ON/OFF, остальные как есть
+func payload(val interface{}) string {
+	if b, ok := val.(bool); ok {
+		if b {
+			return "ON"
+		}
+		return "OFF"
+	}
+	return fmt.Sprint(val)
+}
+
+// parsePayload Разбор значения команды: ON/OFF, true/false или число
+func parsePayload(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "on", "true":
+		return 1, nil
+	case "off", "false":
+		return 0, nil
+	}
+	return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+}
+
+// onCommand Запись значения, пришедшего в Topic/device/tag/set
+func (p *Publisher) onCommand(_ paho.Client, msg paho.Message) {
+	name := strings.TrimSuffix(strings.TrimPrefix(msg.Topic(), p.conf.Topic+"/"), "/set")
+	tag := p.conf.Ctrl.FindTag(name)
+	if tag == nil || !controller.Writable(tag) {
+		log.Printf("MQTT command for unknown or read only tag %s", name)
+		return
+	}
+
+	value, err := parsePayload(string(msg.Payload()))
+	if err != nil {
+		log.Printf("MQTT command %s bad value %q", name, msg.Payload())
+		return
+	}
+
+	// Запись ждет очереди шины, обработчик сообщений не блокируем
+	go func() {
+		if err := p.conf.Ctrl.WriteTag(tag, value); err != nil {
+			log.Printf("MQTT write %s = %v error: %s", name, value, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,168 @@
+package mqtt
+
+import (
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"math"
+	"modbus2prometheus/controller"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		val  interface{}
+		want string
+	}{
+		{true, "ON"},
+		{false, "OFF"},
+		{uint16(7), "7"},
+		{int32(-12), "-12"},
+		{float32(21.5), "21.5"},
+		{float64(0.125), "0.125"},
+		{"SN-001", "SN-001"},
+	}
+
+	for _, tt := range tests {
+		if got := payload(tt.val); got != tt.want {
+			t.Errorf("payload(%v) = %q, want %q", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestParsePayload(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+		ok   bool
+	}{
+		{"ON", 1, true},
+		{"on", 1, true},
+		{" true ", 1, true},
+		{"OFF", 0, true},
+		{"false", 0, true},
+		{"42", 42, true},
+		{"-3", -3, true},
+		{"21.5", 21.5, true},
+		{"21,5", 21.5, true},
+		{"1e3", 1000, true},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"1,5,5", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePayload(tt.s)
+		if tt.ok && (err != nil || math.Abs(got-tt.want) > 1e-12) {
+			t.Errorf("parsePayload(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parsePayload(%q) = %v, want error", tt.s, got)
+		}
+	}
+}
+
+// TestBroker Публикация в настоящий брокер, запускается при заданном MQTT_TEST_URL:
+//
+//	docker compose -f docker/docker-compose.yml --profile mqtt up -d mosquitto
+//	MQTT_TEST_URL=tcp://localhost:1883 go test ./mqtt -run TestBroker -v
+func TestBroker(t *testing.T) {
+	url := os.Getenv("MQTT_TEST_URL")
+	if url == "" {
+		t.Skip("MQTT_TEST_URL is not set")
+	}
+
+	// Соединение с шиной не открывается, значения тегов задаются вручную
+	ctrl, err := controller.New(&controller.Configuration{MetricPrefix: "mqtt_test_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ctrl.AddConnection(&controller.ConnectionConfiguration{Url: "tcp://127.0.0.1:1", Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := conn.AddDevice("boiler", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := &controller.Tag{Name: "temp", Device: dev, Address: 1, Type: controller.TYPE_FLOAT32, Method: controller.READ}
+	pump := &controller.Tag{Name: "pump", Device: dev, Address: 2, Type: controller.TYPE_BOOL, Method: controller.READ}
+	for _, tag := range []*controller.Tag{temp, pump} {
+		if err := ctrl.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctrl.Lock()
+	temp.LastValue, temp.LastRead = float32(21.5), time.Now()
+	pump.LastValue, pump.LastRead = true, time.Now()
+	ctrl.Unlock()
+
+	topic := "m2ptest" + time.Now().Format("150405")
+	want := map[string]string{
+		topic + "/status":      "online",
+		topic + "/boiler/temp": "21.5",
+		topic + "/boiler/pump": "ON",
+		"homeassistant/sensor/" + topic + "/boiler_temp/config":        "",
+		"homeassistant/binary_sensor/" + topic + "/boiler_pump/config": "",
+	}
+
+	var mu sync.Mutex
+	got := make(map[string]string)
+	sub := paho.NewClient(paho.NewClientOptions().AddBroker(url).SetClientID(topic + "_sub"))
+	if tok := sub.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("connect to %s: %v", url, tok.Error())
+	}
+	defer sub.Disconnect(100)
+	handler := func(_ paho.Client, msg paho.Message) {
+		mu.Lock()
+		got[msg.Topic()] = string(msg.Payload())
+		mu.Unlock()
+	}
+	for _, filter := range []string{topic + "/#", "homeassistant/+/" + topic + "/#"} {
+		if tok := sub.Subscribe(filter, 1, handler); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+			t.Fatalf("subscribe %s: %v", filter, tok.Error())
+		}
+	}
+
+	p, err := New(Config{Url: url, ClientId: topic, Topic: topic, Qos: 1, Discovery: true, Ctrl: ctrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		complete := true
+		for name := range want {
+			if _, ok := got[name]; !ok {
+				complete = false
+			}
+		}
+		mu.Unlock()
+		if complete || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	mu.Lock()
+	for name, value := range want {
+		published, ok := got[name]
+		if !ok {
+			t.Errorf("%s was not published", name)
+		} else if value != "" && published != value {
+			t.Errorf("%s = %q, want %q", name, published, value)
+		}
+	}
+	mu.Unlock()
+
+	p.Stop()
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if status := got[topic+"/status"]; status != "offline" {
+		t.Errorf("status after Stop = %q, want offline", status)
+	}
+}
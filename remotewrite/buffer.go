@@ -0,0 +1,128 @@
+package remotewrite
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const bufferExt = ".snappy"
+
+// bufItem Один неотправленный запрос, на диске хранится только имя файла
+type bufItem struct {
+	name string
+	data []byte
+	size int64
+}
+
+// buffer Очередь неотправленных запросов. Если задан каталог, запросы хранятся в файлах
+// и переживают перезапуск, иначе в памяти. При переполнении отбрасываются самые старые.
+type buffer struct {
+	dir     string
+	maxSize int64
+	items   []bufItem
+	size    int64
+}
+
+func newBuffer(dir string, maxSize int64) (*buffer, error) {
+	b := &buffer{dir: dir, maxSize: maxSize}
+	if dir == "" {
+		return b, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Подхватываем неотправленное до перезапуска, имена файлов упорядочены по времени
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), bufferExt) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		b.items = append(b.items, bufItem{name: name, size: info.Size()})
+		b.size += info.Size()
+	}
+	if len(b.items) > 0 {
+		log.Printf("Remote write: %d buffered requests found in %s", len(b.items), dir)
+	}
+	b.trim()
+
+	return b, nil
+}
+
+func (b *buffer) len() int {
+	return len(b.items)
+}
+
+// push Добавляет запрос в конец очереди
+func (b *buffer) push(data []byte) error {
+	item := bufItem{size: int64(len(data))}
+	if b.dir == "" {
+		item.data = data
+	} else {
+		// Пишем во временный файл, чтобы при сбое не осталось обрезанного запроса
+		item.name = fmt.Sprintf("%020d%s", time.Now().UnixNano(), bufferExt)
+		tmp := filepath.Join(b.dir, item.name+".tmp")
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(b.dir, item.name)); err != nil {
+			return err
+		}
+	}
+
+	b.items = append(b.items, item)
+	b.size += item.size
+	b.trim()
+
+	return nil
+}
+
+// peek Самый старый запрос
+func (b *buffer) peek() ([]byte, error) {
+	item := b.items[0]
+	if b.dir == "" {
+		return item.data, nil
+	}
+	return os.ReadFile(filepath.Join(b.dir, item.name))
+}
+
+// pop Удаляет самый старый запрос
+func (b *buffer) pop() {
+	item := b.items[0]
+	b.items = b.items[1:]
+	b.size -= item.size
+	if b.dir != "" {
+		if err := os.Remove(filepath.Join(b.dir, item.name)); err != nil {
+			log.Printf("Remote write: can not remove %s: %s", item.name, err.Error())
+		}
+	}
+}
+
+// trim Отбрасывает самые старые запросы, пока буфер больше maxSize
+func (b *buffer) trim() {
+	dropped := 0
+	for b.size > b.maxSize && len(b.items) > 1 {
+		b.pop()
+		dropped++
+	}
+	if dropped > 0 {
+		log.Printf("Remote write: buffer is full, %d oldest requests dropped", dropped)
+	}
+}
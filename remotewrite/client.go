@@ -0,0 +1,99 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/mcuadros/go-defaults"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client Отправка запросов remote_write на один адрес
+type Client struct {
+	url         string
+	username    string
+	password    string
+	bearerToken string
+	timeout     time.Duration
+	http        *http.Client
+}
+
+// NewClient Клиент с адресом и авторизацией из conf
+func NewClient(conf Config) *Client {
+	defaults.SetDefaults(&conf)
+	return &Client{
+		url:         conf.Url,
+		username:    conf.Username,
+		password:    conf.Password,
+		bearerToken: conf.BearerToken,
+		timeout:     conf.Timeout,
+		http:        &http.Client{Timeout: conf.Timeout},
+	}
+}
+
+// Encode Тело запроса: WriteRequest, сжатый snappy
+func Encode(series []Series) []byte {
+	return snappy.Encode(nil, marshalWriteRequest(series))
+}
+
+// ParseSeries Метки ряда из имени метрики вида name{key="value",...}
+func ParseSeries(metric string) ([]Label, error) {
+	return parseSeries(metric)
+}
+
+// rejectError Ответ 4xx, кроме 429
+type rejectError struct {
+	status string
+	body   string
+}
+
+func (e *rejectError) Error() string {
+	return fmt.Sprintf("rejected with %s: %s", e.status, e.body)
+}
+
+// IsRejected Сервер отверг данные, повторная отправка не поможет
+func IsRejected(err error) bool {
+	var rejErr *rejectError
+	return errors.As(err, &rejErr)
+}
+
+// Post Отправляет тело, полученное от Encode
+func (c *Client) Post(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "modbus2prometheus")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests:
+		return &rejectError{resp.Status, strings.TrimSpace(string(body))}
+	}
+	return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,105 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Label Метка ряда
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample Значение ряда, время в миллисекундах
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Series Временной ряд протокола remote_write
+type Series struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// parseSeries Разбор имени метрики вида name{key="value",...} в отсортированные метки
+func parseSeries(metric string) ([]Label, error) {
+	name, rest, found := strings.Cut(metric, "{")
+	labels := []Label{{"__name__", name}}
+	if found {
+		rest = strings.TrimSuffix(rest, "}")
+		for rest != "" {
+			key, tail, ok := strings.Cut(rest, "=")
+			if !ok {
+				return nil, fmt.Errorf("bad metric %s", metric)
+			}
+			quoted, err := strconv.QuotedPrefix(tail)
+			if err != nil {
+				return nil, fmt.Errorf("bad metric %s: %w", metric, err)
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("bad metric %s: %w", metric, err)
+			}
+			labels = append(labels, Label{key, value})
+			rest = strings.TrimPrefix(tail[len(quoted):], ",")
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels, nil
+}
+
+// Кодирование protobuf сообщения WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	return appendBytes(b, field, []byte(s))
+}
+
+// marshalWriteRequest Сериализация рядов в WriteRequest
+func marshalWriteRequest(series []Series) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.Labels {
+			msg = appendString(msg[:0], 1, l.Name)
+			msg = appendString(msg, 2, l.Value)
+			ts = appendBytes(ts, 1, msg)
+		}
+		for _, sample := range s.Samples {
+			msg = appendTag(msg[:0], 1, wireFixed64)
+			msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(sample.Value))
+			msg = appendTag(msg, 2, wireVarint)
+			msg = binary.AppendUvarint(msg, uint64(sample.Timestamp))
+			ts = appendBytes(ts, 2, msg)
+		}
+		req = appendBytes(req, 1, ts)
+	}
+
+	return req
+}
@@ -0,0 +1,82 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalWriteRequest(t *testing.T) {
+	series := []Series{
+		{
+			Labels:  []Label{{"__name__", "up"}, {"job", "a"}},
+			Samples: []Sample{{Value: 1.5, Timestamp: 1000}},
+		},
+		{
+			Labels:  []Label{{"__name__", "x"}},
+			Samples: []Sample{{Value: 0, Timestamp: 0}, {Value: -2, Timestamp: 1700000000000}},
+		},
+	}
+
+	want, err := hex.DecodeString(strings.Join([]string{
+		"0a28",                                     // timeseries, 40 байт
+		"0a0e", "0a085f5f6e616d655f5f", "12027570", // label __name__="up"
+		"0a08", "0a036a6f62", "120161", // label job="a"
+		"120c", "09000000000000f83f", "10e807", // sample 1.5 @1000
+		"0a2e",                                   // timeseries, 46 байт
+		"0a0d", "0a085f5f6e616d655f5f", "120178", // label __name__="x"
+		"120b", "090000000000000000", "1000", // sample 0 @0
+		"1210", "0900000000000000c0", "1080d095ffbc31", // sample -2 @1700000000000
+	}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := marshalWriteRequest(series); !bytes.Equal(got, want) {
+		t.Errorf("marshalWriteRequest() =\n%x\nwant\n%x", got, want)
+	}
+	if got := marshalWriteRequest(nil); len(got) != 0 {
+		t.Errorf("marshalWriteRequest(nil) = %x, want empty", got)
+	}
+}
+
+func TestParseSeries(t *testing.T) {
+	tests := []struct {
+		metric string
+		want   []Label
+	}{
+		{"up", []Label{{"__name__", "up"}}},
+		{"up{}", []Label{{"__name__", "up"}}},
+		{
+			`modbus_value{tag="temp",device="16",group="heat",unit="C"}`,
+			[]Label{{"__name__", "modbus_value"}, {"device", "16"}, {"group", "heat"}, {"tag", "temp"}, {"unit", "C"}},
+		},
+		{
+			`temp_info{device="boiler",desc="Котел \"Viessmann\"",path="C:\\boiler"}`,
+			[]Label{{"__name__", "temp_info"}, {"desc", `Котел "Viessmann"`}, {"device", "boiler"}, {"path", `C:\boiler`}},
+		},
+		{
+			`mode_state{state="a,b}=c",device="1"}`,
+			[]Label{{"__name__", "mode_state"}, {"device", "1"}, {"state", "a,b}=c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSeries(tt.metric)
+		if err != nil {
+			t.Errorf("parseSeries(%s) error: %s", tt.metric, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSeries(%s) =\n%q\nwant\n%q", tt.metric, got, tt.want)
+		}
+	}
+
+	for _, metric := range []string{`up{tag}`, `up{tag="x}`, `up{tag=x}`} {
+		if labels, err := parseSeries(metric); err == nil {
+			t.Errorf("parseSeries(%s) = %q, want error", metric, labels)
+		}
+	}
+}
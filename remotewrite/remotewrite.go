@@ -0,0 +1,240 @@
+package remotewrite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/mcuadros/go-defaults"
+	"log"
+	"modbus2prometheus/controller"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config Настройки отправки метрик по протоколу Prometheus remote_write
+type Config struct {
+	Url         string
+	Interval    time.Duration `default:"30s"` // Период отправки
+	Timeout     time.Duration `default:"10s"` // Таймаут одного запроса
+	Username    string
+	Password    string
+	BearerToken string
+	BufferDir   string // Каталог для неотправленных запросов, если пусто то буфер в памяти
+	MaxBuffer   int64  `default:"67108864"` // Максимальный размер буфера в байтах
+	Ctrl        *controller.Controller
+}
+
+// Pusher Копит значения тегов с временем их чтения и периодически отправляет их
+// вместе с остальными метриками экспортера. Пока адрес недоступен, запросы копятся в буфере.
+// Значения собираются в отдельной горутине, чтобы долгая отправка не переполняла подписку.
+type Pusher struct {
+	conf    Config
+	client  *Client
+	buf     *buffer // Только для горутины отправки
+	updates <-chan controller.Update
+	tagSet  map[string]bool // Ряды тегов, которые не берем из снимка метрик
+
+	mu     sync.Mutex
+	series map[*controller.Tag]*Series
+
+	collected chan struct{} // Закрывается, когда сбор значений остановлен
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// New Запускает отправку до вызова Stop, вызывается до запуска опроса контроллера
+func New(conf Config) (*Pusher, error) {
+	defaults.SetDefaults(&conf)
+	if conf.Url == "" {
+		return nil, fmt.Errorf("remote write url is empty")
+	}
+
+	buf, err := newBuffer(conf.BufferDir, conf.MaxBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pusher{
+		conf:    conf,
+		client:  NewClient(conf),
+		buf:     buf,
+		updates: conf.Ctrl.Subscribe(),
+		series:  make(map[*controller.Tag]*Series),
+		tagSet:  make(map[string]bool),
+	}
+	for _, tag := range conf.Ctrl.Tags() {
+		p.tagSet[tag.Metric] = true
+	}
+	p.start()
+
+	return p, nil
+}
+
+func (p *Pusher) start() {
+	p.collected = make(chan struct{})
+	p.done = make(chan struct{})
+
+	p.wg.Add(2)
+	go p.collect()
+	go p.run()
+}
+
+// Stop Сохраняет накопленное в буфер, делает последнюю попытку отправки и останавливается
+func (p *Pusher) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// collect Копит значения из подписки до закрытия подписки или Stop. При остановке
+// забирает то, что уже лежит в подписке.
+func (p *Pusher) collect() {
+	defer p.wg.Done()
+	defer close(p.collected)
+
+	for {
+		select {
+		case <-p.done:
+			for {
+				select {
+				case u, ok := <-p.updates:
+					if !ok {
+						return
+					}
+					p.add(u)
+				default:
+					return
+				}
+			}
+		case u, ok := <-p.updates:
+			if !ok {
+				return
+			}
+			p.add(u)
+		}
+	}
+}
+
+// run Упаковывает накопленное в буфер и отправляет его по таймеру
+func (p *Pusher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.conf.Interval)
+	defer ticker.Stop()
+
+	// Оставшееся с прошлого запуска отправляем сразу
+	p.send()
+
+	for {
+		select {
+		case <-p.done:
+			<-p.collected
+			p.flush()
+			p.send()
+			return
+		case <-ticker.C:
+			p.flush()
+			p.send()
+		}
+	}
+}
+
+// add Добавляет значение тега со временем его чтения
+func (p *Pusher) add(u controller.Update) {
+	val, ok := controller.ValToFloat(u.Value)
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.series[u.Tag]
+	if s == nil {
+		labels, err := parseSeries(u.Tag.Metric)
+		if err != nil {
+			log.Printf("Remote write: %s", err.Error())
+			return
+		}
+		s = &Series{Labels: labels}
+		p.series[u.Tag] = s
+	}
+	s.Samples = append(s.Samples, Sample{Value: val, Timestamp: u.Time.UnixMilli()})
+}
+
+// snapshot Текущие значения остальных метрик экспортера: счетчики, состояние соединений
+func (p *Pusher) snapshot() (series []Series) {
+	var bb bytes.Buffer
+	metrics.WritePrometheus(&bb, false)
+	now := time.Now().UnixMilli()
+
+	scanner := bufio.NewScanner(&bb)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 || p.tagSet[line[:i]] {
+			continue
+		}
+		val, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		labels, err := parseSeries(line[:i])
+		if err != nil {
+			continue
+		}
+		series = append(series, Series{Labels: labels, Samples: []Sample{{val, now}}})
+	}
+
+	return
+}
+
+// flush Упаковывает накопленные значения в запрос и кладет его в буфер
+func (p *Pusher) flush() {
+	var series []Series
+	p.mu.Lock()
+	for tag, s := range p.series {
+		if len(s.Samples) > 0 {
+			series = append(series, *s)
+		}
+		delete(p.series, tag)
+	}
+	p.mu.Unlock()
+	series = append(series, p.snapshot()...)
+	if len(series) == 0 {
+		return
+	}
+
+	if err := p.buf.push(Encode(series)); err != nil {
+		log.Printf("Remote write: can not buffer request: %s", err.Error())
+	}
+}
+
+// send Отправляет буфер начиная с самых старых запросов, до первой ошибки
+func (p *Pusher) send() {
+	for p.buf.len() > 0 {
+		data, err := p.buf.peek()
+		if err == nil {
+			err = p.client.Post(data)
+		}
+		if err == nil {
+			p.buf.pop()
+			continue
+		}
+
+		// Сервер отверг данные, повтор не поможет
+		if IsRejected(err) {
+			log.Printf("Remote write: %s, request dropped", err.Error())
+			p.buf.pop()
+			continue
+		}
+
+		log.Printf("Remote write: %s, %d requests buffered", err.Error(), p.buf.len())
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package remotewrite
+
+import (
+	"bytes"
+	"github.com/golang/snappy"
+	"io"
+	"modbus2prometheus/controller"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPusherCollectWhileSending Медленный сервер не должен задерживать чтение подписки
+func TestPusherCollectWhileSending(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		data, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, data)
+		if err != nil {
+			t.Errorf("snappy: %s", err)
+		}
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	conf := Config{Url: srv.URL, Interval: 10 * time.Millisecond, Timeout: time.Second, MaxBuffer: 1 << 20}
+	buf, err := newBuffer("", conf.MaxBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan controller.Update)
+	p := &Pusher{
+		conf:    conf,
+		client:  NewClient(conf),
+		buf:     buf,
+		updates: updates,
+		series:  make(map[*controller.Tag]*Series),
+		tagSet:  make(map[string]bool),
+	}
+	p.start()
+
+	tag := &controller.Tag{Name: "temp", Metric: `rw_test_value{device="boiler"}`}
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		select {
+		case updates <- controller.Update{Tag: tag, Value: float64(i), Time: start.Add(time.Duration(i) * time.Millisecond)}:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("update %d was not collected while sending", i)
+		}
+	}
+	p.Stop()
+
+	if n := p.buf.len(); n != 0 {
+		t.Errorf("%d requests left in buffer after Stop", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, body := range bodies {
+		found = found || bytes.Contains(body, []byte("rw_test_value"))
+	}
+	if !found {
+		t.Errorf("tag samples were not sent in %d requests", len(bodies))
+	}
+}
@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"modbus2prometheus/alerts"
+	"strings"
+	"time"
+)
+
+// Данные кнопок уведомлений о тревогах: "alert:ack:<правило>" или "alert:<длительность>:<правило>"
+const alertCallbackPrefix = "alert:"
+
+// Ограничение Telegram на размер данных кнопки
+const maxCallbackData = 64
+
+// Варианты заглушки тревоги на кнопках
+var silenceButtons = []struct {
+	label    string
+	duration string
+}{
+	{"Тишина 1ч", "1h"},
+	{"8ч", "8h"},
+	{"24ч", "24h"},
+}
+
+// alertKeyboard Кнопки подтверждения и заглушки тревоги
+func alertKeyboard(rule string) tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Принято", alertCallbackPrefix+"ack:"+rule),
+	)
+	for _, b := range silenceButtons {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(b.label, alertCallbackPrefix+b.duration+":"+rule))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// sendAlert Отправляет уведомление о тревоге владельцам, к активной тревоге добавляются кнопки
+func (s *BotState) sendAlert(e alerts.Event) {
+	for id := range s.Owners {
+		msg := tgbotapi.NewMessage(id, strings.TrimSpace(e.Text))
+		// Для правил со слишком длинным именем кнопок нет, остаются команды /alerts и /silence
+		if e.Kind != alerts.EVENT_RESOLVED && len(alertCallbackPrefix+"24h:"+e.Rule) <= maxCallbackData {
+			msg.ReplyMarkup = alertKeyboard(e.Rule)
+		}
+		if _, err := s.bot.Send(msg); err != nil {
+			log.Printf("Telegram send error: %s", err.Error())
+		}
+	}
+}
+
+// alertCallback Обрабатывает нажатие кнопки под уведомлением о тревоге, возвращает false,
+// если кнопка не относится к тревогам
+func (s *BotState) alertCallback(update tgbotapi.Update) bool {
+	query := update.CallbackQuery
+	if s.Alerts == nil || !strings.HasPrefix(query.Data, alertCallbackPrefix) {
+		return false
+	}
+
+	who, exists := s.Owners[query.From.ID]
+	if !exists {
+		return true
+	}
+	if who == "" {
+		who = query.From.UserName
+	}
+
+	action, rule, _ := strings.Cut(strings.TrimPrefix(query.Data, alertCallbackPrefix), ":")
+	var text string
+	var err error
+	if action == "ack" {
+		if err = s.Alerts.Ack(rule, who); err == nil {
+			text = "Принято: " + who
+		}
+	} else {
+		var d time.Duration
+		var silence alerts.Silence
+		if d, err = time.ParseDuration(action); err == nil {
+			if silence, err = s.Alerts.Silence(rule, d, who); err == nil {
+				text = "Тишина до " + silence.Until.Format("02.01 15:04") + ": " + who
+			}
+		}
+	}
+	if err != nil {
+		text = "Ошибка: " + err.Error()
+	}
+	log.Printf("[%d:%s] %s", query.From.ID, query.From.UserName, query.Data)
+
+	if _, err := s.bot.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Printf("Telegram callback error: %s", err.Error())
+	}
+
+	// Отметка в самом уведомлении, кнопки при этом убираются
+	if err == nil && query.Message != nil {
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, query.Message.Text+"\n\n"+text)
+		if _, err := s.bot.Send(edit); err != nil {
+			log.Printf("Telegram edit error: %s", err.Error())
+		}
+	}
+
+	return true
+}
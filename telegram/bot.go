@@ -1,26 +1,41 @@
 package telegram
 
 import (
-	"fmt"
+	"errors"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"log"
+	"modbus2prometheus/alerts"
+	"modbus2prometheus/connwatch"
 	"modbus2prometheus/controller"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Адрес Bot API и паузы между попытками подключения, в тестах подменяются
+var (
+	apiEndpoint     = tgbotapi.APIEndpoint
+	minConnectDelay = 5 * time.Second
+	maxConnectDelay = 5 * time.Minute
+)
+
 type BotConfig struct {
 	BotToken string
 	Owners   map[int64]string
 	Api      []ICommand
 	Ctrl     *controller.Controller
+	Alerts   *alerts.Manager    // Если задан, уведомления о тревогах рассылаются владельцам
+	Conns    *connwatch.Watcher // Если задан, владельцам рассылаются уведомления о связи с устройствами
 }
 
 type BotState struct {
 	BotConfig
 	lastCommandTime time.Time        // Время вызова команды
 	currentCommand  ICommand         // Текущая команда, если nil то ждем любую
-	bot             *tgbotapi.BotAPI // Бот
+	bot             *tgbotapi.BotAPI // Бот, nil до подключения к Telegram
+
+	mu   sync.Mutex
+	done chan struct{}
 }
 
 func reply(bot *tgbotapi.BotAPI, update tgbotapi.Update, cmd ICommand) {
@@ -46,7 +61,83 @@ func reply(bot *tgbotapi.BotAPI, update tgbotapi.Update, cmd ICommand) {
 	}
 }
 
-func New(conf BotConfig) {
+// New Запускает бота, обработка команд идет в отдельной горутине до вызова Stop.
+// Если Telegram недоступен (например, на объекте нет сети при загрузке), подключение
+// повторяется в фоне, а уведомления ждут в очередях подписок. Ошибка возвращается,
+// только если Telegram отверг токен.
+func New(conf BotConfig) (*BotState, error) {
+	state := &BotState{BotConfig: conf, lastCommandTime: time.Now(), done: make(chan struct{})}
+
+	bot, err := newBotAPI(conf.BotToken)
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return nil, err
+	}
+
+	// Подписываемся до подключения, события тревог и связи копятся в очередях подписок
+	var alertEvents <-chan alerts.Event
+	if conf.Alerts != nil {
+		alertEvents = conf.Alerts.Subscribe()
+	}
+	var connEvents <-chan connwatch.Event
+	if conf.Conns != nil {
+		connEvents = conf.Conns.Subscribe()
+	}
+
+	if err != nil {
+		log.Printf("Can not connect to telegram, retrying in background: %s", err.Error())
+		go func() {
+			if bot := state.connect(); bot != nil {
+				state.start(bot, alertEvents, connEvents)
+			}
+		}()
+		return state, nil
+	}
+
+	state.start(bot, alertEvents, connEvents)
+	return state, nil
+}
+
+func newBotAPI(token string) (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(token, apiEndpoint)
+}
+
+// connect Повторяет подключение к Telegram с растущей паузой до успеха или Stop
+func (s *BotState) connect() *tgbotapi.BotAPI {
+	delay := minConnectDelay
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case <-time.After(delay):
+		}
+
+		bot, err := newBotAPI(s.BotToken)
+		if err == nil {
+			return bot
+		}
+		log.Printf("Can not connect to telegram: %s", err.Error())
+
+		delay *= 2
+		if delay > maxConnectDelay {
+			delay = maxConnectDelay
+		}
+	}
+}
+
+// start Запускает обработку команд и рассылку уведомлений после подключения к Telegram
+func (s *BotState) start(bot *tgbotapi.BotAPI, alertEvents <-chan alerts.Event, connEvents <-chan connwatch.Event) {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	s.bot = bot
+	s.mu.Unlock()
+
+	state, conf := s, s.BotConfig
 	commandMap := make(map[string]ICommand)
 	var botCommands []tgbotapi.BotCommand
 
@@ -58,17 +149,12 @@ func New(conf BotConfig) {
 		})
 	}
 
-	bot, err := tgbotapi.NewBotAPI(conf.BotToken)
-	if err != nil {
-		log.Panic(err)
-	}
-	state := BotState{conf, time.Now(), nil, bot}
 	bot.Debug = true
 
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
 	command := tgbotapi.NewSetMyCommands(botCommands...)
-	_, err = bot.Request(command)
+	_, err := bot.Request(command)
 	{
 		if err != nil {
 			log.Printf("Request err: %s", err.Error())
@@ -117,6 +203,9 @@ func New(conf BotConfig) {
 						state.currentCommand = nil
 					}
 				}
+			} else if update.CallbackQuery != nil && state.alertCallback(update) {
+				// Кнопки под уведомлениями о тревогах работают независимо от текущей команды
+				continue
 			} else if update.CallbackQuery != nil && state.currentCommand != nil { // Пришло нажатие на inline кнопку
 				if !state.currentCommand.Callback(bot, update) {
 					continue
@@ -126,6 +215,42 @@ func New(conf BotConfig) {
 			}
 		}
 
-		log.Fatal(fmt.Errorf("no more updates"))
+		log.Println("Telegram updates stopped")
 	}()
+
+	if alertEvents != nil {
+		go func() {
+			for e := range alertEvents {
+				state.sendAlert(e)
+			}
+		}()
+	}
+	if connEvents != nil {
+		go func() {
+			for e := range connEvents {
+				state.Send(e.Text)
+			}
+		}()
+	}
+}
+
+// Send Отправляет сообщение всем владельцам бота
+func (s *BotState) Send(text string) {
+	for id := range s.Owners {
+		msg := tgbotapi.NewMessage(id, strings.TrimSpace(text))
+		if _, err := s.bot.Send(msg); err != nil {
+			log.Printf("Telegram send error: %s", err.Error())
+		}
+	}
+}
+
+// Stop Останавливает получение обновлений и попытки подключения
+func (s *BotState) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close(s.done)
+	if s.bot != nil {
+		s.bot.StopReceivingUpdates()
+	}
 }
@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"io"
+	"modbus2prometheus/alerts"
+	"modbus2prometheus/controller"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeApi Bot API, который отвечает ошибкой сети на первые failures запросов getMe
+type fakeApi struct {
+	mu       sync.Mutex
+	failures int
+	getMe    int
+	messages []string
+	reply    string // Ответ на getMe, по умолчанию успешный
+}
+
+func (f *fakeApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]; method {
+	case "getMe":
+		f.getMe++
+		if f.getMe <= f.failures {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		if f.reply != "" {
+			io.WriteString(w, f.reply)
+			return
+		}
+		io.WriteString(w, `{"ok":true,"result":{"id":1,"is_bot":true,"username":"test_bot"}}`)
+	case "getUpdates":
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, `{"ok":true,"result":[]}`)
+	case "sendMessage":
+		r.ParseForm()
+		f.messages = append(f.messages, r.Form.Get("text"))
+		io.WriteString(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)
+	default:
+		io.WriteString(w, `{"ok":true,"result":true}`)
+	}
+}
+
+func (f *fakeApi) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.getMe, len(f.messages)
+}
+
+func testApi(t *testing.T, api *fakeApi) {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	endpoint, minDelay := apiEndpoint, minConnectDelay
+	apiEndpoint, minConnectDelay = srv.URL+"/bot%s/%s", 10*time.Millisecond
+	t.Cleanup(func() { apiEndpoint, minConnectDelay = endpoint, minDelay })
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewRetriesConnect(t *testing.T) {
+	api := &fakeApi{failures: 3}
+	testApi(t, api)
+
+	ctrl, err := controller.New(&controller.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerter, err := alerts.New(alerts.Config{
+		Rules:    []alerts.RuleConfig{{Name: "always", Expr: "1 > 0", Message: "Тревога"}},
+		Interval: 10 * time.Millisecond,
+		Ctrl:     ctrl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot, err := New(BotConfig{BotToken: "token", Owners: map[int64]string{1: "owner"}, Ctrl: ctrl, Alerts: alerter})
+	if err != nil {
+		t.Fatalf("New() error: %s", err)
+	}
+	// Тревога срабатывает, пока Telegram недоступен, и уходит после подключения
+	alerter.Start()
+	defer alerter.Stop()
+	defer bot.Stop()
+
+	waitFor(t, "alert message", func() bool {
+		_, messages := api.counts()
+		return messages > 0
+	})
+	if getMe, _ := api.counts(); getMe != 4 {
+		t.Errorf("getMe called %d times, want 4", getMe)
+	}
+	api.mu.Lock()
+	if !strings.Contains(api.messages[0], "Тревога") {
+		t.Errorf("message %q, want alert text", api.messages[0])
+	}
+	api.mu.Unlock()
+}
+
+func TestNewRejectedToken(t *testing.T) {
+	testApi(t, &fakeApi{reply: `{"ok":false,"error_code":401,"description":"Unauthorized"}`})
+
+	if bot, err := New(BotConfig{BotToken: "bad"}); err == nil {
+		bot.Stop()
+		t.Fatal("New() with rejected token, want error")
+	}
+}
+
+func TestStopWhileConnecting(t *testing.T) {
+	api := &fakeApi{failures: 1 << 30}
+	testApi(t, api)
+
+	bot, err := New(BotConfig{BotToken: "token"})
+	if err != nil {
+		t.Fatalf("New() error: %s", err)
+	}
+	waitFor(t, "retry", func() bool {
+		getMe, _ := api.counts()
+		return getMe > 1
+	})
+	bot.Stop()
+
+	// Попытка, начатая до Stop, может завершиться, новых после нее нет
+	time.Sleep(50 * time.Millisecond)
+	stopped, _ := api.counts()
+	time.Sleep(200 * time.Millisecond)
+	if getMe, _ := api.counts(); getMe != stopped {
+		t.Errorf("getMe called %d times after Stop", getMe-stopped)
+	}
+}
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"modbus2prometheus/alerts"
+	"strings"
+	"time"
+)
+
+const timeFormat = "02.01 15:04"
+
+// AlertsCommand Список активных тревог и заглушек
+type AlertsCommand struct {
+	alerts *alerts.Manager
+}
+
+func NewAlertsCommand(manager *alerts.Manager) *AlertsCommand {
+	return &AlertsCommand{alerts: manager}
+}
+
+func (a *AlertsCommand) Command() string {
+	return "alerts"
+}
+
+func (a *AlertsCommand) Description() string {
+	return "Активные тревоги"
+}
+
+func (a *AlertsCommand) Reply() string {
+	var text string
+	for _, alert := range a.alerts.Alerts() {
+		if alert.State == alerts.STATE_INACTIVE.String() && alert.Silenced == nil {
+			continue
+		}
+
+		title := alert.Name
+		if alert.Message != "" {
+			title += " (" + alert.Message + ")"
+		}
+		text += title + ": " + alert.State
+		if alert.FiredAt != nil {
+			text += " с " + alert.FiredAt.Format(timeFormat)
+		}
+		if alert.AckedBy != "" {
+			text += ", принято " + alert.AckedBy
+		}
+		if alert.Silenced != nil {
+			text += ", тишина до " + alert.Silenced.Format(timeFormat)
+		}
+		text += "\n"
+	}
+
+	if text == "" {
+		return "Тревог нет"
+	}
+	return text
+}
+
+func (a *AlertsCommand) Action(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
+	return true
+}
+
+func (a *AlertsCommand) Callback(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
+	return true
+}
+
+// SilenceCommand Заглушка тревог: "/silence" показывает заглушки, "/silence имя 8h" глушит
+// тревогу, "/silence имя off" снимает заглушку
+type SilenceCommand struct {
+	alerts *alerts.Manager
+	owners map[int64]string
+	reply  string
+}
+
+func NewSilenceCommand(manager *alerts.Manager, owners map[int64]string) *SilenceCommand {
+	return &SilenceCommand{alerts: manager, owners: owners}
+}
+
+func (s *SilenceCommand) Command() string {
+	return "silence"
+}
+
+func (s *SilenceCommand) Description() string {
+	return "Заглушить тревогу: /silence имя 8h, снять: /silence имя off"
+}
+
+func (s *SilenceCommand) Reply() string {
+	text := s.reply
+	s.reply = ""
+	return text
+}
+
+// list Действующие заглушки
+func (s *SilenceCommand) list() string {
+	var text string
+	for _, silence := range s.alerts.Silences() {
+		text += silence.Alert + ": до " + silence.Until.Format(timeFormat)
+		if silence.By != "" {
+			text += " (" + silence.By + ")"
+		}
+		text += "\n"
+	}
+
+	if text == "" {
+		text = "Заглушенных тревог нет\n"
+	}
+	return text + "\n" + s.Description()
+}
+
+func (s *SilenceCommand) Action(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) != 2 {
+		s.reply = s.list()
+		return true
+	}
+
+	name, arg := args[0], args[1]
+	if arg == "off" {
+		if err := s.alerts.Unsilence(name); err != nil {
+			s.reply = "Ошибка: " + err.Error()
+		} else {
+			s.reply = "Заглушка " + name + " снята"
+		}
+		return true
+	}
+
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		s.reply = "Некорректная длительность " + arg + ", например 30m, 8h"
+		return true
+	}
+
+	who := s.owners[update.Message.From.ID]
+	if who == "" {
+		who = update.Message.From.UserName
+	}
+	silence, err := s.alerts.Silence(name, d, who)
+	if err != nil {
+		s.reply = "Ошибка: " + err.Error()
+	} else {
+		s.reply = "Тревога " + name + " заглушена до " + silence.Until.Format(timeFormat)
+	}
+
+	return true
+}
+
+func (s *SilenceCommand) Callback(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
+	return true
+}
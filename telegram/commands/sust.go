@@ -5,6 +5,7 @@ import (
 	"log"
 	"modbus2prometheus/controller"
 	"strconv"
+	"strings"
 )
 
 type UstCommand struct {
@@ -51,7 +52,7 @@ func (u *UstCommand) Action(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
 		var buttons []tgbotapi.InlineKeyboardButton
 		for _, tag := range u.ctrl.Tags() {
 			if tag.Group == "ust" {
-				buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(tag.GetName(), tag.Name))
+				buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(tag.GetName(), tag.FullName()))
 				//row := tgbotapi.NewInlineKeyboardRow()
 				//keyboard = append(keyboard, row)
 			}
@@ -71,14 +72,19 @@ func (u *UstCommand) Action(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
 	} else {
 		text := "Значение устновлено "
 		// Пытаемся изменить значение
-		val, err := strconv.ParseFloat(update.Message.Text, 32)
+		val, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(update.Message.Text), ",", ".", 1), 64)
 		if err != nil {
 			text = "Введено не корректное значение!"
-		}
-
-		err = u.ctrl.WriteTag(u.currentTag, val)
-		if err != nil {
-			text = "Ошибка записи: " + err.Error()
+		} else if err = u.ctrl.WriteTag(u.currentTag, val); err != nil {
+			if controller.IsValueError(err) {
+				text = "Недопустимое значение: " + err.Error()
+			} else {
+				text = "Ошибка записи: " + err.Error()
+			}
+		} else {
+			u.ctrl.RLock()
+			text += controller.ValToStrWithUnit(u.currentTag)
+			u.ctrl.RUnlock()
 		}
 
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
@@ -108,7 +114,14 @@ func (u *UstCommand) Callback(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool
 	} else if !controller.Writable(u.currentTag) {
 		text = "Тег " + tagName + " не может быть записан, см. конфигурацию"
 	} else {
-		text = "Введите значени для " + u.currentTag.DisplayName + ":"
+		text = "Введите значени для " + u.currentTag.DisplayName
+		if u.currentTag.Unit != "" {
+			text += ", " + u.currentTag.Unit
+		}
+		if limits := u.currentTag.Limits(); limits != "" {
+			text += " (" + limits + ")"
+		}
+		text += ":"
 	}
 
 	// And finally, send a message containing the data received.
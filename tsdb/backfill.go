@@ -0,0 +1,130 @@
+package tsdb
+
+import (
+	"log"
+	"modbus2prometheus/remotewrite"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	backfillBatch = 10000       // Значений в одном запросе
+	backfillDelay = time.Minute // Отставание от текущего времени, чтобы не пропустить значения в очереди
+)
+
+// backfill Отправляет сохраненные значения по remote_write. Отправленное отмечается
+// в файле backfill.pos, поэтому после обрыва связи или перезапуска отправка
+// продолжается с места остановки.
+func (s *Store) backfill() {
+	defer s.wg.Done()
+
+	client := remotewrite.NewClient(s.conf.Backfill)
+	ticker := time.NewTicker(s.conf.BackfillInterval)
+	defer ticker.Stop()
+
+	pos := s.loadPos()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			pos = s.backfillOnce(client, pos)
+		}
+	}
+}
+
+func (s *Store) posPath() string {
+	return filepath.Join(s.conf.Dir, "backfill.pos")
+}
+
+// loadPos Время, до которого значения уже отправлены. При первом запуске отправка
+// начинается с текущего момента.
+func (s *Store) loadPos() int64 {
+	data, err := os.ReadFile(s.posPath())
+	if err == nil {
+		if pos, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			return pos
+		}
+	}
+
+	pos := time.Now().UnixMilli()
+	s.savePos(pos)
+	return pos
+}
+
+func (s *Store) savePos(pos int64) {
+	tmp := s.posPath() + ".tmp"
+	err := os.WriteFile(tmp, []byte(strconv.FormatInt(pos, 10)+"\n"), 0644)
+	if err == nil {
+		err = os.Rename(tmp, s.posPath())
+	}
+	if err != nil {
+		log.Printf("Tsdb backfill position save error: %s", err.Error())
+	}
+}
+
+// backfillOnce Отправляет значения после pos интервалами по BlockDuration до первой ошибки
+func (s *Store) backfillOnce(client *remotewrite.Client, pos int64) int64 {
+	until := time.Now().Add(-backfillDelay).UnixMilli()
+	for pos < until {
+		end := min(pos+s.conf.BlockDuration.Milliseconds(), until)
+		records, err := s.query(nil, pos+1, end, 0)
+		if err != nil {
+			log.Printf("Tsdb backfill error: %s", err.Error())
+			return pos
+		}
+
+		for len(records) > 0 {
+			n := min(len(records), backfillBatch)
+			err := client.Post(remotewrite.Encode(s.toSeries(records[:n])))
+			if err != nil && !remotewrite.IsRejected(err) {
+				log.Printf("Tsdb backfill error: %s", err.Error())
+				// Часть интервала уже отправлена, повторно её примет получатель как дубликаты
+				return pos
+			}
+			if err != nil {
+				log.Printf("Tsdb backfill: %s, %d values dropped", err.Error(), n)
+			}
+			records = records[n:]
+		}
+
+		pos = end
+		s.savePos(pos)
+	}
+
+	return pos
+}
+
+// toSeries Группирует значения по рядам с метками метрик тегов
+func (s *Store) toSeries(records []record) []remotewrite.Series {
+	s.mu.Lock()
+	metrics := make(map[uint32]string, len(s.byId))
+	for id, info := range s.byId {
+		metrics[id] = info.metric
+	}
+	s.mu.Unlock()
+
+	index := make(map[uint32]int)
+	var series []remotewrite.Series
+	for _, r := range records {
+		i, ok := index[r.id]
+		if !ok {
+			if metrics[r.id] == "" {
+				continue
+			}
+			labels, err := remotewrite.ParseSeries(metrics[r.id])
+			if err != nil {
+				continue
+			}
+			i = len(series)
+			index[r.id] = i
+			series = append(series, remotewrite.Series{Labels: labels})
+		}
+		series[i].Samples = append(series[i].Samples, remotewrite.Sample{Value: r.value, Timestamp: r.time})
+	}
+
+	return series
+}
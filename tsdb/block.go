@@ -0,0 +1,117 @@
+package tsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/snappy"
+	"math"
+	"os"
+	"sort"
+)
+
+// Сигнатура файла блока
+const blockMagic = "M2PBLK1\n"
+
+// Блок - сжатые значения закрытого интервала. Значения каждого ряда отсортированы по времени,
+// время хранится разностями с предыдущим, значение - xor с предыдущим, все в varint.
+// Повторяющиеся значения занимают по байту, результат дополнительно сжимается snappy.
+//
+//	uvarint(число рядов)
+//	для каждого ряда: uvarint(id) uvarint(n) n*varint(dt) n*uvarint(xor)
+
+// writeBlock Записывает записи журнала в файл блока через временный файл
+func writeBlock(path string, records []record) error {
+	series := make(map[uint32][]record)
+	for _, r := range records {
+		series[r.id] = append(series[r.id], r)
+	}
+	ids := make([]uint32, 0, len(series))
+	for id := range series {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var body []byte
+	body = binary.AppendUvarint(body, uint64(len(ids)))
+	for _, id := range ids {
+		rs := series[id]
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].time < rs[j].time })
+
+		body = binary.AppendUvarint(body, uint64(id))
+		body = binary.AppendUvarint(body, uint64(len(rs)))
+		var prevTime int64
+		for _, r := range rs {
+			body = binary.AppendVarint(body, r.time-prevTime)
+			prevTime = r.time
+		}
+		var prevBits uint64
+		for _, r := range rs {
+			bits := math.Float64bits(r.value)
+			body = binary.AppendUvarint(body, bits^prevBits)
+			prevBits = bits
+		}
+	}
+
+	data := append([]byte(blockMagic), snappy.Encode(nil, body)...)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readBlock Читает значения блока, если ids не пустой, то только этих рядов
+func readBlock(path string, ids map[uint32]bool) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(blockMagic)) {
+		return nil, fmt.Errorf("%s: not a block file", path)
+	}
+	body, err := snappy.Decode(nil, data[len(blockMagic):])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	r := bytes.NewReader(body)
+	bad := fmt.Errorf("%s: corrupted block", path)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, bad
+	}
+
+	var records []record
+	for i := uint64(0); i < count; i++ {
+		id, err1 := binary.ReadUvarint(r)
+		n, err2 := binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil || n > uint64(len(body)) {
+			return nil, bad
+		}
+
+		times := make([]int64, n)
+		var t int64
+		for j := range times {
+			dt, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, bad
+			}
+			t += dt
+			times[j] = t
+		}
+		var bits uint64
+		for j := range times {
+			x, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, bad
+			}
+			bits ^= x
+			if len(ids) == 0 || ids[uint32(id)] {
+				records = append(records, record{uint32(id), times[j], math.Float64frombits(bits)})
+			}
+		}
+	}
+
+	return records, nil
+}
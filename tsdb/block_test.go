@@ -0,0 +1,93 @@
+package tsdb
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBlockRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1700000000000.blk")
+
+	// Записи журнала идут вперемешку по рядам и не обязательно по времени
+	records := []record{
+		{7, 1700000002000, 21.5},
+		{2, 1700000000000, -1},
+		{7, 1700000000000, 21.5},
+		{7, 1700000001000, 21.75},
+		{2, 1700000001000, math.MaxFloat64},
+		{300000, 1600000000000, 0},
+		{7, 1700000003000, math.Inf(-1)},
+		{2, 1700000001000, 5},
+	}
+	if err := writeBlock(path, records); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+
+	// Ряды по возрастанию id, значения ряда по времени, одинаковое время в порядке записи
+	want := []record{
+		{2, 1700000000000, -1},
+		{2, 1700000001000, math.MaxFloat64},
+		{2, 1700000001000, 5},
+		{7, 1700000000000, 21.5},
+		{7, 1700000001000, 21.75},
+		{7, 1700000002000, 21.5},
+		{7, 1700000003000, math.Inf(-1)},
+		{300000, 1600000000000, 0},
+	}
+	got, err := readBlock(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readBlock() =\n%v\nwant\n%v", got, want)
+	}
+
+	got, err = readBlock(path, map[uint32]bool{7: true, 8: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want[3:7]) {
+		t.Errorf("readBlock(7) =\n%v\nwant\n%v", got, want[3:7])
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.blk")
+	if err := writeBlock(empty, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readBlock(empty, nil); err != nil || len(got) != 0 {
+		t.Errorf("readBlock(empty) = %v, %v, want no records", got, err)
+	}
+}
+
+func TestReadBlockCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.blk")
+	if err := writeBlock(good, []record{{1, 1000, 1}, {1, 2000, 2}, {2, 1000, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"not a block": []byte("hello world"),
+		"truncated":   data[:len(data)-3],
+		"bad snappy":  append([]byte(blockMagic), 0xff, 0xff, 0xff, 0xff),
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, name+".blk")
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if records, err := readBlock(path, nil); err == nil {
+			t.Errorf("%s: readBlock() = %v, want error", name, records)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package tsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"modbus2prometheus/history"
+	"net/http"
+	"time"
+)
+
+func badRequest(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte("Bad Request: " + err.Error()))
+}
+
+// Handler GET /api/v1/tsdb/query?tag=device/name&from=...&to=...&step=...
+// Параметры и ответ как у /api/v1/history, по умолчанию отдаются последние сутки.
+func (s *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+
+		tag := s.conf.Ctrl.FindTag(q.Get("tag"))
+		if tag == nil {
+			badRequest(w, fmt.Errorf("tag %q not found", q.Get("tag")))
+			return
+		}
+
+		now := time.Now()
+		from, to := now.Add(-24*time.Hour), now
+		var step time.Duration
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = history.ParseTime(v, now); err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = history.ParseTime(v, now); err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+		if v := q.Get("step"); v != "" {
+			if step, err = time.ParseDuration(v); err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+
+		points, err := s.Query(tag.FullName(), from, to)
+		if errors.Is(err, ErrTooManyPoints) {
+			badRequest(w, err)
+			return
+		}
+		if err != nil {
+			log.Printf("Tsdb query error: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal Server Error: " + err.Error()))
+			return
+		}
+
+		res := history.JsonHistory{
+			Tag:    tag.FullName(),
+			Unit:   tag.Unit,
+			From:   from.Format(time.RFC3339),
+			To:     to.Format(time.RFC3339),
+			Points: history.Downsample(points, step),
+		}
+		if step > 0 {
+			res.Step = step.String()
+		}
+
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Println("Cannot send response")
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package tsdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/mcuadros/go-defaults"
+	"log"
+	"math"
+	"modbus2prometheus/controller"
+	"modbus2prometheus/history"
+	"modbus2prometheus/remotewrite"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config Настройки встроенного хранилища значений тегов
+type Config struct {
+	Dir              string
+	Retention        time.Duration      `default:"720h"`    // Сколько хранить значения
+	BlockDuration    time.Duration      `default:"2h"`      // Интервал журнала, после которого он сжимается в блок
+	SyncInterval     time.Duration      `default:"10s"`     // Период сброса журнала на диск
+	MaxQueryPoints   int                `default:"1000000"` // Больше значений один запрос тега не вернет
+	Backfill         remotewrite.Config // Куда дозаливать сохраненные значения по remote_write, если задан Url
+	BackfillInterval time.Duration      `default:"30s"`
+	Ctrl             *controller.Controller
+}
+
+// seriesInfo Ряд хранилища, один на тег
+type seriesInfo struct {
+	id     uint32
+	name   string // Полное имя тега device/name
+	metric string // Имя метрики с метками, для дозаливки
+}
+
+// blockFile Сжатый блок значений за интервал [start, end]
+type blockFile struct {
+	start int64
+	end   int64
+	path  string
+}
+
+// Store Хранилище значений тегов на диске. Каждое прочитанное значение дописывается
+// в журнал текущего интервала, закрытые интервалы сжимаются в блоки, блоки старше
+// Retention удаляются.
+type Store struct {
+	conf    Config
+	updates <-chan controller.Update
+
+	mu         sync.Mutex
+	series     map[string]*seriesInfo
+	byId       map[uint32]*seriesInfo
+	seriesFile *os.File
+	head       *wal
+	blocks     []blockFile
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New Открывает хранилище и начинает запись, вызывается до запуска опроса контроллера
+func New(conf Config) (*Store, error) {
+	defaults.SetDefaults(&conf)
+	if conf.Dir == "" {
+		return nil, fmt.Errorf("tsdb dir is empty")
+	}
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		conf:   conf,
+		series: make(map[string]*seriesInfo),
+		byId:   make(map[uint32]*seriesInfo),
+		done:   make(chan struct{}),
+	}
+	if err := s.loadSeries(); err != nil {
+		return nil, err
+	}
+	if err := s.loadFiles(); err != nil {
+		s.seriesFile.Close()
+		return nil, err
+	}
+	s.applyRetention()
+	s.updates = conf.Ctrl.Subscribe()
+
+	s.wg.Add(1)
+	go s.run()
+	if conf.Backfill.Url != "" {
+		s.wg.Add(1)
+		go s.backfill()
+	}
+
+	return s, nil
+}
+
+// Stop Останавливает запись и сбрасывает журнал на диск
+func (s *Store) Stop() {
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.head != nil {
+		if err := s.head.close(); err != nil {
+			log.Printf("Tsdb close error: %s", err.Error())
+		}
+		s.head = nil
+	}
+	s.seriesFile.Close()
+}
+
+func (s *Store) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.conf.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case u, ok := <-s.updates:
+			if !ok {
+				return
+			}
+			val, ok := controller.ValToFloat(u.Value)
+			if !ok || math.IsNaN(val) || math.IsInf(val, 0) {
+				continue
+			}
+			if err := s.append(u.Tag, u.Time.UnixMilli(), val); err != nil {
+				log.Printf("Tsdb write error: %s", err.Error())
+			}
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+// loadSeries Читает список рядов, строки "id<TAB>name<TAB>metric", поздние строки
+// обновляют метрику ряда
+func (s *Store) loadSeries() error {
+	path := filepath.Join(s.conf.Dir, "series")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		info := &seriesInfo{uint32(id), parts[1], parts[2]}
+		s.series[info.name] = info
+		s.byId[info.id] = info
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+
+	s.seriesFile = file
+	return nil
+}
+
+// loadFiles Находит блоки и журналы. Журналы закрытых интервалов сжимаются,
+// журнал текущего интервала открывается на дозапись.
+func (s *Store) loadFiles() error {
+	entries, err := os.ReadDir(s.conf.Dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(s.conf.Dir, name)
+		var start, end int64
+		switch {
+		case strings.HasSuffix(name, ".blk"):
+			if _, err := fmt.Sscanf(name, "block-%d-%d.blk", &start, &end); err == nil {
+				s.blocks = append(s.blocks, blockFile{start, end, path})
+			}
+		case strings.HasSuffix(name, ".wal"):
+			if _, err := fmt.Sscanf(name, "head-%d.wal", &start); err != nil {
+				continue
+			}
+			if start+s.conf.BlockDuration.Milliseconds() > now && s.head == nil {
+				if s.head, err = openWal(path, start); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.compact(path); err != nil {
+				return err
+			}
+		}
+	}
+	sort.Slice(s.blocks, func(i, j int) bool { return s.blocks[i].start < s.blocks[j].start })
+
+	return nil
+}
+
+// compact Сжимает журнал в блок и удаляет журнал
+func (s *Store) compact(path string) error {
+	records, err := readWal(path)
+	if err != nil {
+		return err
+	}
+
+	if len(records) > 0 {
+		start, end := records[0].time, records[0].time
+		for _, r := range records {
+			start = min(start, r.time)
+			end = max(end, r.time)
+		}
+		blk := blockFile{start, end, filepath.Join(s.conf.Dir, fmt.Sprintf("block-%d-%d.blk", start, end))}
+		if err := writeBlock(blk.path, records); err != nil {
+			return err
+		}
+		s.blocks = append(s.blocks, blk)
+	}
+
+	return os.Remove(path)
+}
+
+// applyRetention Удаляет блоки, все значения которых старше Retention
+func (s *Store) applyRetention() {
+	before := time.Now().Add(-s.conf.Retention).UnixMilli()
+	kept := s.blocks[:0]
+	for _, blk := range s.blocks {
+		if blk.end >= before {
+			kept = append(kept, blk)
+			continue
+		}
+		if err := os.Remove(blk.path); err != nil {
+			log.Printf("Tsdb retention error: %s", err.Error())
+		}
+	}
+	s.blocks = kept
+}
+
+// seriesId Номер ряда тега, новый ряд сразу дописывается в список
+func (s *Store) seriesId(tag *controller.Tag) (uint32, error) {
+	name := tag.FullName()
+	info := s.series[name]
+	if info != nil && info.metric == tag.Metric {
+		return info.id, nil
+	}
+
+	if info == nil {
+		info = &seriesInfo{id: uint32(len(s.byId) + 1), name: name}
+		for s.byId[info.id] != nil {
+			info.id++
+		}
+		s.series[name] = info
+		s.byId[info.id] = info
+	}
+	info.metric = tag.Metric
+	_, err := fmt.Fprintf(s.seriesFile, "%d\t%s\t%s\n", info.id, info.name, info.metric)
+
+	return info.id, err
+}
+
+// append Дописывает значение в журнал, при переходе в новый интервал старый журнал сжимается
+func (s *Store) append(tag *controller.Tag, t int64, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.seriesId(tag)
+	if err != nil {
+		return err
+	}
+
+	blockMs := s.conf.BlockDuration.Milliseconds()
+	start := t - t%blockMs
+	if s.head == nil || start > s.head.start {
+		if s.head != nil {
+			if err := s.head.close(); err != nil {
+				return err
+			}
+			if err := s.compact(s.head.path); err != nil {
+				return err
+			}
+			s.head = nil
+			s.applyRetention()
+		}
+
+		s.head, err = openWal(filepath.Join(s.conf.Dir, fmt.Sprintf("head-%d.wal", start)), start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.head.append(record{id, t, value})
+}
+
+// sync Сбрасывает журнал и список рядов на диск
+func (s *Store) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.head != nil {
+		if err := s.head.sync(); err != nil {
+			log.Printf("Tsdb sync error: %s", err.Error())
+		}
+	}
+	s.seriesFile.Sync()
+}
+
+// ErrTooManyPoints Запрос вернул бы больше MaxQueryPoints значений
+var ErrTooManyPoints = errors.New("too many points")
+
+// query Значения рядов ids (все, если пусто) за [from, to], отсортированные по времени,
+// не больше limit, если он задан. Файлы читаются без блокировки, чтобы запрос за долгий
+// интервал не останавливал запись. Если журнал сжали или блок удалили во время чтения,
+// запрос повторяется по новому списку файлов.
+func (s *Store) query(ids map[uint32]bool, from, to int64, limit int) (res []record, err error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		res, err = s.queryFiles(ids, from, to, limit)
+		if !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	return
+}
+
+func (s *Store) queryFiles(ids map[uint32]bool, from, to int64, limit int) ([]record, error) {
+	s.mu.Lock()
+	var blocks []blockFile
+	for _, blk := range s.blocks {
+		if blk.end >= from && blk.start <= to {
+			blocks = append(blocks, blk)
+		}
+	}
+	var headPath string
+	if s.head != nil && s.head.start <= to {
+		if err := s.head.w.Flush(); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		headPath = s.head.path
+	}
+	s.mu.Unlock()
+
+	var res []record
+	keep := func(records []record) error {
+		for _, r := range records {
+			if r.time >= from && r.time <= to && (len(ids) == 0 || ids[r.id]) {
+				res = append(res, r)
+			}
+		}
+		if limit > 0 && len(res) > limit {
+			return fmt.Errorf("%w: more than %d in range", ErrTooManyPoints, limit)
+		}
+		return nil
+	}
+
+	for _, blk := range blocks {
+		records, err := readBlock(blk.path, ids)
+		if err == nil {
+			err = keep(records)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Журнал читается до последней целой записи, дописываемые в это время записи не мешают
+	if headPath != "" {
+		records, err := readWal(headPath)
+		if err == nil {
+			err = keep(records)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].time < res[j].time })
+	return res, nil
+}
+
+// Query Значения тега за [from, to]
+func (s *Store) Query(name string, from, to time.Time) ([]history.Point, error) {
+	s.mu.Lock()
+	info := s.series[name]
+	s.mu.Unlock()
+	if info == nil {
+		return nil, nil
+	}
+
+	records, err := s.query(map[uint32]bool{info.id: true}, from.UnixMilli(), to.UnixMilli(), s.conf.MaxQueryPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]history.Point, len(records))
+	for i, r := range records {
+		points[i] = history.Point{Time: r.time, Value: r.value}
+	}
+	return points, nil
+}
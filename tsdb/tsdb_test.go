@@ -0,0 +1,113 @@
+package tsdb
+
+import (
+	"errors"
+	"modbus2prometheus/controller"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, conf Config) *Store {
+	ctrl, err := controller.New(&controller.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Dir, conf.Ctrl = t.TempDir(), ctrl
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestQueryLimit(t *testing.T) {
+	s := testStore(t, Config{BlockDuration: time.Minute, MaxQueryPoints: 50})
+	dev := &controller.Device{Name: "boiler"}
+	temp := &controller.Tag{Name: "temp", Device: dev, Metric: `temp{device="boiler"}`}
+	other := &controller.Tag{Name: "other", Device: dev, Metric: `other{device="boiler"}`}
+
+	// Значения за два интервала: первый сжимается в блок, второй остается в журнале
+	start := time.Now().Truncate(time.Minute).Add(-time.Minute).UnixMilli()
+	for i := int64(0); i < 100; i++ {
+		ts := start + i*1000
+		if err := s.append(temp, ts, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.append(other, ts, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.blocks) != 1 {
+		t.Fatalf("%d blocks, want 1", len(s.blocks))
+	}
+
+	from, to := time.UnixMilli(start), time.UnixMilli(start+99*1000)
+	if _, err := s.Query("boiler/temp", from, to); !errors.Is(err, ErrTooManyPoints) {
+		t.Errorf("Query() error %v, want %v", err, ErrTooManyPoints)
+	}
+
+	points, err := s.Query("boiler/temp", from, time.UnixMilli(start+49*1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 50 {
+		t.Fatalf("Query() returned %d points, want 50", len(points))
+	}
+	for i, p := range points {
+		if p.Time != start+int64(i)*1000 || p.Value != float64(i) {
+			t.Errorf("point %d = %+v", i, p)
+		}
+	}
+
+	// Дозаливка читает все ряды без ограничения
+	records, err := s.query(nil, start, start+99*1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 200 {
+		t.Errorf("query() returned %d records, want 200", len(records))
+	}
+}
+
+// TestQueryWhileAppending Запросы идут параллельно с записью, которая сжимает журналы в блоки
+func TestQueryWhileAppending(t *testing.T) {
+	s := testStore(t, Config{BlockDuration: 50 * time.Millisecond})
+	tag := &controller.Tag{Name: "temp", Device: &controller.Device{Name: "boiler"}}
+	start := time.Now().UnixMilli()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(0); i < 2000; i++ {
+			if err := s.append(tag, start+i, float64(i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		points, err := s.Query("boiler/temp", time.UnixMilli(start), time.UnixMilli(start+2000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 1; j < len(points); j++ {
+			if points[j].Time <= points[j-1].Time {
+				t.Fatalf("points out of order at %d: %d after %d", j, points[j].Time, points[j-1].Time)
+			}
+		}
+	}
+	wg.Wait()
+
+	points, err := s.Query("boiler/temp", time.UnixMilli(start), time.UnixMilli(start+2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2000 {
+		t.Errorf("Query() returned %d points, want 2000", len(points))
+	}
+}
@@ -0,0 +1,104 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// Запись журнала фиксированного размера: id ряда uint32, время в мс int64, значение float64.
+// Фиксированный размер позволяет после сбоя просто отрезать недописанный хвост.
+const walRecordSize = 4 + 8 + 8
+
+// record Одно значение ряда
+type record struct {
+	id    uint32
+	time  int64
+	value float64
+}
+
+func (r record) encode(b []byte) {
+	binary.LittleEndian.PutUint32(b[0:], r.id)
+	binary.LittleEndian.PutUint64(b[4:], uint64(r.time))
+	binary.LittleEndian.PutUint64(b[12:], math.Float64bits(r.value))
+}
+
+func decodeRecord(b []byte) record {
+	return record{
+		id:    binary.LittleEndian.Uint32(b[0:]),
+		time:  int64(binary.LittleEndian.Uint64(b[4:])),
+		value: math.Float64frombits(binary.LittleEndian.Uint64(b[12:])),
+	}
+}
+
+// wal Журнал головного интервала, в него только дописываются записи
+type wal struct {
+	path  string
+	start int64 // Начало интервала в мс
+	file  *os.File
+	w     *bufio.Writer
+}
+
+// openWal Открывает журнал на дозапись, недописанная последняя запись отрезается
+func openWal(path string, start int64) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := info.Size() - info.Size()%walRecordSize
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &wal{path: path, start: start, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (l *wal) append(r record) error {
+	var b [walRecordSize]byte
+	r.encode(b[:])
+	_, err := l.w.Write(b[:])
+	return err
+}
+
+// sync Сбрасывает буфер на диск
+func (l *wal) sync() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *wal) close() error {
+	if err := l.sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// readWal Читает все целые записи журнала
+func readWal(path string) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]record, 0, len(data)/walRecordSize)
+	for off := 0; off+walRecordSize <= len(data); off += walRecordSize {
+		records = append(records, decodeRecord(data[off:]))
+	}
+	return records, nil
+}
@@ -0,0 +1,79 @@
+package tsdb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "head.wal")
+	records := []record{
+		{1, 1700000000000, 21.5},
+		{2, 1700000000000, -3},
+		{1, 1700000001000, 22},
+	}
+
+	l, err := openWal(path, 1700000000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := l.append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сбой посреди записи: на диске осталась только часть следующей записи
+	var torn [walRecordSize]byte
+	record{3, 1700000002000, 1}.encode(torn[:])
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(torn[:walRecordSize/2]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	got, err := readWal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("readWal() with torn tail = %v, want %v", got, records)
+	}
+
+	// Открытие отрезает хвост, новая запись не смещается
+	l, err = openWal(path, 1700000000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(records)*walRecordSize) {
+		t.Errorf("wal size after open = %d, want %d", info.Size(), len(records)*walRecordSize)
+	}
+
+	next := record{4, 1700000003000, 0.5}
+	if err := l.append(next); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = readWal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(records, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("readWal() = %v, want %v", got, want)
+	}
+}